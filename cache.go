@@ -0,0 +1,224 @@
+package qjskatex
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/graemephi/goldmark-qjs-katex/katex"
+)
+
+// Cache caches rendered TeX. Implementations must be safe for concurrent use,
+// e.g., to share rendered TeX between processes.
+type Cache interface {
+	// Get returns the entry stored for key, if any. The strings in key may be
+	// modified after Get returns, so must not be retained.
+	Get(key CacheKey) (CacheEntry, bool)
+	// Put stores entry for key. The entry must not be modified.
+	Put(key CacheKey, entry CacheEntry)
+}
+
+// CacheKey identifies rendered TeX.
+type CacheKey struct {
+	// TeX is the TeX source.
+	TeX string
+	// Mode is the mode the TeX is rendered in.
+	Mode katex.Mode
+	// Options identifies the other options the TeX is rendered with, including
+	// any macros defined by earlier TeX in the document.
+	Options string
+}
+
+// CacheEntry is the result of rendering TeX.
+type CacheEntry struct {
+	// HTML is the rendered HTML.
+	HTML string
+	// Err is the error rendering returned, if any.
+	Err error
+	// ParseError is the parse error KaTeX reported, whether or not it was
+	// rendered.
+	ParseError *katex.ParseError
+	// Macros is the macro table after rendering, if rendering with
+	// Extension.DocumentMacros changed it. It must not be modified.
+	Macros map[string]string
+}
+
+// CacheStats reports the effectiveness and size of a cache.
+type CacheStats struct {
+	// Hits and Misses count the TeX found and not found in the cache.
+	Hits, Misses uint64
+	// Entries is the number of entries in the cache.
+	Entries int
+	// Bytes approximates the size of the TeX and HTML held by the cache.
+	Bytes int
+	// Evictions counts the entries removed to keep the cache within its limits.
+	Evictions uint64
+}
+
+// CacheInspector is a Cache whose contents can be inspected and reset, such as
+// MemoryCache. Extension.CacheStats, Extension.ResetCache and
+// Extension.RangeCache only report the hits and misses of other caches.
+type CacheInspector interface {
+	Cache
+	// Stats reports the Entries, Bytes and Evictions of the cache.
+	Stats() CacheStats
+	// Reset removes all entries.
+	Reset()
+	// Range calls f for each entry until f returns false. f may call other
+	// methods of the cache.
+	Range(f func(key CacheKey, entry CacheEntry) bool)
+}
+
+// DefaultCacheMaxBytes is the approximate number of bytes of TeX and rendered
+// HTML the cache holds when neither Extension.CacheMaxEntries nor
+// Extension.CacheMaxBytes is set.
+const DefaultCacheMaxBytes = 64 << 20
+
+// MemoryCache is a bounded, in-memory Cache. It evicts entries with the CLOCK
+// algorithm, an approximation of LRU that lets hits share a read lock.
+type MemoryCache struct {
+	mu      sync.RWMutex
+	entries map[CacheKey]*cacheEntry
+
+	// ring holds the entries in the order they are considered for eviction,
+	// starting at hand.
+	ring []*cacheEntry
+	hand int
+
+	size       int
+	evictions  uint64
+	maxEntries int
+	maxBytes   int
+}
+
+type cacheEntry struct {
+	// referenced is set to 1 when the entry is loaded, and cleared when the
+	// clock hand passes over it. Only entries that are not referenced are
+	// evicted.
+	referenced int32
+
+	key   CacheKey
+	value CacheEntry
+}
+
+// NewMemoryCache returns a cache that holds at most maxEntries entries and
+// about maxBytes bytes of TeX and HTML. Limits that are zero are not enforced.
+func NewMemoryCache(maxEntries, maxBytes int) *MemoryCache {
+	return &MemoryCache{
+		entries:    make(map[CacheKey]*cacheEntry),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+// entrySize approximates the memory held by an entry.
+func entrySize(key CacheKey, value CacheEntry) int {
+	size := len(key.TeX) + len(key.Options) + len(value.HTML)
+	for name, macro := range value.Macros {
+		size += len(name) + len(macro)
+	}
+	return size
+}
+
+// Get implements Cache.
+func (c *MemoryCache) Get(key CacheKey) (CacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if atomic.LoadInt32(&e.referenced) == 0 {
+		atomic.StoreInt32(&e.referenced, 1)
+	}
+	return e.value, true
+}
+
+// Put implements Cache.
+func (c *MemoryCache) Put(key CacheKey, value CacheEntry) {
+	size := entrySize(key, value)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size += size - entrySize(e.key, e.value)
+		e.value = value
+	} else {
+		e := &cacheEntry{key: key, value: value}
+		c.entries[key] = e
+		c.ring = append(c.ring, e)
+		c.size += size
+	}
+	for c.full() {
+		c.evict()
+	}
+}
+
+func (c *MemoryCache) full() bool {
+	return (c.maxEntries > 0 && len(c.ring) > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes)
+}
+
+// evict advances the clock hand until it finds an entry that has not been
+// referenced since the hand last passed it, and removes that entry.
+func (c *MemoryCache) evict() {
+	for {
+		if c.hand >= len(c.ring) {
+			c.hand = 0
+		}
+		e := c.ring[c.hand]
+		if atomic.SwapInt32(&e.referenced, 0) == 1 {
+			c.hand++
+			continue
+		}
+		last := len(c.ring) - 1
+		c.ring[c.hand] = c.ring[last]
+		c.ring[last] = nil
+		c.ring = c.ring[:last]
+		delete(c.entries, e.key)
+		c.size -= entrySize(e.key, e.value)
+		c.evictions++
+		return
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *MemoryCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.ring)
+}
+
+// Stats implements CacheInspector. Hits and Misses are not counted.
+func (c *MemoryCache) Stats() CacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return CacheStats{Entries: len(c.ring), Bytes: c.size, Evictions: c.evictions}
+}
+
+// Reset implements CacheInspector. Evictions are also reset.
+func (c *MemoryCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[CacheKey]*cacheEntry)
+	c.ring = nil
+	c.hand = 0
+	c.size = 0
+	c.evictions = 0
+}
+
+// Range implements CacheInspector. Entries are visited in no particular order,
+// and entries added or removed during the call may or may not be visited.
+func (c *MemoryCache) Range(f func(key CacheKey, entry CacheEntry) bool) {
+	c.mu.RLock()
+	entries := make([]cacheEntry, len(c.ring))
+	for i, e := range c.ring {
+		entries[i].key, entries[i].value = e.key, e.value
+	}
+	c.mu.RUnlock()
+	for i := range entries {
+		if !f(entries[i].key, entries[i].value) {
+			return
+		}
+	}
+}
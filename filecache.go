@@ -0,0 +1,111 @@
+package qjskatex
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/graemephi/goldmark-qjs-katex/katex"
+)
+
+// FileCache is a Cache that persists entries as files in a directory, so that
+// rendered TeX is kept between runs. Entries are keyed by a hash of the TeX,
+// mode, options and katex.Version, so entries rendered by other versions of
+// KaTeX are never used. Stale entries are not removed.
+//
+// Entries with errors other than parse errors, e.g., katex.ErrTooLarge, are not
+// stored. Errors reading and writing files are ignored, and treated as misses.
+type FileCache struct {
+	dir string
+}
+
+// fileEntry is the encoding of a CacheEntry in a file.
+type fileEntry struct {
+	HTML       string            `json:"html"`
+	ParseError *katex.ParseError `json:"parseError,omitempty"`
+	Thrown     bool              `json:"thrown,omitempty"`
+	Macros     map[string]string `json:"macros,omitempty"`
+}
+
+// NewFileCache returns a FileCache that stores entries in dir, creating it if
+// necessary. Multiple processes may share dir.
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCache{dir: dir}, nil
+}
+
+// path returns the path of the file that holds the entry for key.
+func (c *FileCache) path(key CacheKey) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, s := range []string{katex.Version, key.Options, key.TeX} {
+		binary.LittleEndian.PutUint64(buf[:], uint64(len(s)))
+		h.Write(buf[:])
+		h.Write([]byte(s))
+	}
+	binary.LittleEndian.PutUint64(buf[:], uint64(key.Mode))
+	h.Write(buf[:])
+	name := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(c.dir, name[:2], name[2:])
+}
+
+// Get implements Cache.
+func (c *FileCache) Get(key CacheKey) (CacheEntry, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	var fe fileEntry
+	if err := json.Unmarshal(data, &fe); err != nil {
+		return CacheEntry{}, false
+	}
+	entry := CacheEntry{HTML: fe.HTML, ParseError: fe.ParseError, Macros: fe.Macros}
+	if fe.Thrown && fe.ParseError != nil {
+		entry.Err = fe.ParseError
+	}
+	return entry, true
+}
+
+// Put implements Cache.
+func (c *FileCache) Put(key CacheKey, entry CacheEntry) {
+	thrown := entry.Err != nil && entry.Err == error(entry.ParseError)
+	if entry.Err != nil && !thrown {
+		return
+	}
+	data, err := json.Marshal(&fileEntry{
+		HTML:       entry.HTML,
+		ParseError: entry.ParseError,
+		Thrown:     thrown,
+		Macros:     entry.Macros,
+	})
+	if err != nil {
+		return
+	}
+	path := c.path(key)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	// Write to a temporary file and rename it, so that concurrent readers never
+	// see a partially written entry.
+	f, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
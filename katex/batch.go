@@ -0,0 +1,219 @@
+package katex
+
+/*
+#include "katex.h"
+
+// Copied from quickjs/quickjs.c
+#define JS_STRING_LEN_MAX ((1 << 30) - 1)
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Item is TeX to render with RenderBatch.
+type Item struct {
+	// TeX is the TeX source.
+	TeX []byte
+	// Options are the options to render TeX with. If nil, TeX is rendered
+	// inline, with KaTeX's defaults.
+	Options *Options
+}
+
+// Result is the result of rendering an Item with RenderBatch.
+type Result struct {
+	// HTML is the rendered HTML. It is empty if Err is not nil.
+	HTML []byte
+	// Err is the error rendering the item returned, as RenderWithOptions would
+	// return it.
+	Err error
+	// ParseError is the parse error KaTeX reported, as RenderReporting would
+	// return it, whether or not it was rendered.
+	ParseError *ParseError
+}
+
+// RenderBatch renders each item as RenderWithOptions would, but with a single
+// call into KaTeX, avoiding the overhead of calling into the QuickJS runtime for
+// each item. It returns the results in the same order as items.
+//
+// Items rendered with Options.GlobalGroup add their definitions to their Macros
+// after the whole batch is rendered, so definitions are not visible to later
+// items in the same batch, even those with the same Macros.
+//
+// If the batch as a whole fails, e.g., with ErrOutOfMemory, every item that was
+// rendered by KaTeX returns the error.
+func RenderBatch(items []Item) []Result {
+	return RenderBatchContext(context.Background(), items)
+}
+
+// RenderBatchContext is like RenderBatch, but stops rendering as soon as
+// possible after ctx is done, as RenderContext does. As the batch is rendered
+// with a single call into KaTeX, interrupting the call fails every item in it
+// with ErrTimeout, including those KaTeX had already rendered.
+func RenderBatchContext(ctx context.Context, items []Item) []Result {
+	for {
+		// As for RenderContext, retrying after ErrClosed uses the replacement
+		// default pool. Items that are not sent to KaTeX, e.g., empty TeX, never
+		// return ErrClosed, so every result is checked.
+		results := defaultPool().RenderBatchContext(ctx, items)
+		if !closed(results) {
+			return results
+		}
+	}
+}
+
+// closed reports whether any of results returned ErrClosed.
+func closed(results []Result) bool {
+	for i := range results {
+		if results[i].Err == ErrClosed {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderBatch is like the package-level RenderBatch, but renders with a runtime
+// in p.
+func (p *Pool) RenderBatch(items []Item) []Result {
+	return p.RenderBatchContext(context.Background(), items)
+}
+
+// RenderBatchContext is like the package-level RenderBatchContext, but renders
+// with a runtime in p.
+func (p *Pool) RenderBatchContext(ctx context.Context, items []Item) []Result {
+	results := make([]Result, len(items))
+	batch := make([]int, 0, len(items))
+	for i, item := range items {
+		switch {
+		case len(item.TeX) == 0:
+		case len(item.TeX) > C.JS_STRING_LEN_MAX:
+			results[i].Err = ErrTooLarge
+		default:
+			batch = append(batch, i)
+		}
+	}
+	if len(batch) == 0 {
+		return results
+	}
+	if ctx.Err() != nil {
+		fail(results, batch, ErrTimeout)
+		return results
+	}
+	interrupt, stop := watch(ctx)
+	defer stop()
+	p.renderBatch(ctx, items, results, batch, interrupt)
+	return results
+}
+
+// fail sets the error of the results of the items in batch to err.
+func fail(results []Result, batch []int, err error) {
+	for _, i := range batch {
+		results[i] = Result{Err: err}
+	}
+}
+
+// batchOptions returns the options of item, which may be nil.
+func (item *Item) batchOptions() *Options {
+	if item.Options == nil {
+		return Inline.options()
+	}
+	return item.Options
+}
+
+// batchItem is an item as katex.js's renderBatch expects it.
+type batchItem struct {
+	TeX         string `json:"tex"`
+	DisplayMode bool   `json:"displayMode"`
+	Warnings    bool   `json:"warnings"`
+	Options     string `json:"options"`
+}
+
+// renderBatch renders the items in batch, the indices of the items to render
+// into results. Batches too large to be represented as a string in the QuickJS
+// runtime are split.
+func (p *Pool) renderBatch(ctx context.Context, items []Item, results []Result, batch []int, interrupt *int32) {
+	encoded := make([]batchItem, len(batch))
+	// Items usually share options, which are only encoded once.
+	var lastOpts *Options
+	var lastEncoded string
+	for j, i := range batch {
+		opts := items[i].batchOptions()
+		if opts != lastOpts {
+			encodedOpts, _ := json.Marshal(&reportingOptions{Options: opts, ReportErrors: true})
+			lastOpts, lastEncoded = opts, string(encodedOpts)
+		}
+		// The TeX is only read while encoding, so it is not copied.
+		tex := *(*string)(unsafe.Pointer(&items[i].TeX))
+		encoded[j] = batchItem{TeX: tex, DisplayMode: opts.DisplayMode, Warnings: opts.Warnings, Options: lastEncoded}
+	}
+	src, err := json.Marshal(encoded)
+	if err != nil {
+		fail(results, batch, ErrBadInput)
+		return
+	}
+	if len(src) > C.JS_STRING_LEN_MAX {
+		if len(batch) == 1 {
+			fail(results, batch, ErrTooLarge)
+			return
+		}
+		p.renderBatch(ctx, items, results, batch[:len(batch)/2], interrupt)
+		p.renderBatch(ctx, items, results, batch[len(batch)/2:], interrupt)
+		return
+	}
+
+	rt, err := p.get(ctx)
+	if err != nil {
+		fail(results, batch, err)
+		return
+	}
+	var size C.size_t
+	var out []byte
+	buf := C.render_batch(rt.state, cref(src), clen(src), &size, (*C.int)(unsafe.Pointer(interrupt)))
+	if buf == nil {
+		err = renderError(size)
+	} else {
+		out = C.GoBytes(unsafe.Pointer(buf), C.int(size))
+		C.free_result(rt.state, buf)
+	}
+
+	var outputs []*string
+	if err == nil && (json.Unmarshal(out, &outputs) != nil || len(outputs) != len(batch)) {
+		err = ErrBadInput
+	}
+	if err != nil {
+		p.put(rt, err)
+		if interrupt != nil && atomic.LoadInt32(interrupt) != 0 {
+			err = ErrTimeout
+		}
+		fail(results, batch, err)
+		return
+	}
+
+	// The runtime is replaced if any item failed other than to parse, as it would
+	// be after rendering the item alone.
+	var putErr error
+	for j, i := range batch {
+		if outputs[j] == nil {
+			results[i].Err = ErrBadInput
+			putErr = ErrBadInput
+			continue
+		}
+		result := Result{HTML: []byte(*outputs[j])}
+		opts := items[i].batchOptions()
+		if len(result.HTML) > 0 && result.HTML[0] == '{' {
+			result.HTML, result.ParseError, result.Err = decodeResult(result.HTML, items[i].TeX, opts)
+			if result.Err != nil {
+				putErr = result.Err
+			} else if result.ParseError != nil && opts.ThrowOnError {
+				result.HTML = result.HTML[:0]
+				result.Err = result.ParseError
+			}
+		}
+		results[i] = result
+	}
+	p.put(rt, putErr)
+}
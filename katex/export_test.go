@@ -0,0 +1,8 @@
+package katex
+
+import "sync/atomic"
+
+// RuntimesCreated returns the number of runtimes p has created.
+func RuntimesCreated(p *Pool) int64 {
+	return atomic.LoadInt64(&p.created)
+}
@@ -0,0 +1,30 @@
+//go:build ignore
+// +build ignore
+
+// gen_stylesheet.go generates stylesheet.go from the bundled KaTeX stylesheet.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+func main() {
+	css, err := ioutil.ReadFile("katex/katex.min.css")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var out bytes.Buffer
+	out.WriteString("// Code generated by gen_stylesheet.go. DO NOT EDIT.\n\n")
+	out.WriteString("//go:generate go run gen_stylesheet.go\n\n")
+	out.WriteString("package katex\n\n")
+	out.WriteString("// Stylesheet is katex.min.css, the stylesheet for the HTML rendered by the\n")
+	out.WriteString("// bundled version of KaTeX. Its fonts are loaded relative to the stylesheet,\n")
+	out.WriteString("// from fonts/.\n")
+	fmt.Fprintf(&out, "const Stylesheet = %q\n", bytes.TrimSpace(css))
+	if err := ioutil.WriteFile("stylesheet.go", out.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -41358,55 +41358,267 @@ const uint8_t qjsc_katex[330802] = {
  0x26, 0x21,
 };
 
-const uint32_t qjsc_api_size = 371;
+const uint32_t qjsc_api_size = 2070;
 
-const uint8_t qjsc_api[371] = {
- 0x01, 0x0f, 0x1c, 0x6b, 0x61, 0x74, 0x65, 0x78,
+const uint8_t qjsc_api[2070] = {
+ 0x01, 0x36, 0x1c, 0x6b, 0x61, 0x74, 0x65, 0x78,
  0x2f, 0x6b, 0x61, 0x74, 0x65, 0x78, 0x2e, 0x6a,
  0x73, 0x22, 0x2e, 0x2f, 0x6b, 0x61, 0x74, 0x65,
  0x78, 0x2f, 0x6b, 0x61, 0x74, 0x65, 0x78, 0x2e,
  0x6d, 0x6a, 0x73, 0x0a, 0x6b, 0x61, 0x74, 0x65,
  0x78, 0x08, 0x77, 0x61, 0x72, 0x6e, 0x08, 0x6e,
- 0x6f, 0x6f, 0x70, 0x0c, 0x72, 0x65, 0x6e, 0x64,
- 0x65, 0x72, 0x06, 0x6d, 0x73, 0x67, 0x0e, 0x63,
- 0x6f, 0x6e, 0x73, 0x6f, 0x6c, 0x65, 0x06, 0x6c,
- 0x6f, 0x67, 0x1e, 0x4b, 0x61, 0x54, 0x65, 0x58,
- 0x20, 0x77, 0x61, 0x72, 0x6e, 0x69, 0x6e, 0x67,
- 0x3a, 0x20, 0x06, 0x74, 0x65, 0x78, 0x16, 0x64,
- 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x4d, 0x6f,
- 0x64, 0x65, 0x10, 0x77, 0x61, 0x72, 0x6e, 0x69,
- 0x6e, 0x67, 0x73, 0x1c, 0x72, 0x65, 0x6e, 0x64,
- 0x65, 0x72, 0x54, 0x6f, 0x53, 0x74, 0x72, 0x69,
- 0x6e, 0x67, 0x18, 0x74, 0x68, 0x72, 0x6f, 0x77,
- 0x4f, 0x6e, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x0e,
- 0xa0, 0x03, 0x01, 0xa2, 0x03, 0x00, 0x00, 0x01,
- 0x00, 0x2c, 0x00, 0x0d, 0x00, 0x06, 0x01, 0x9e,
- 0x01, 0x00, 0x00, 0x00, 0x02, 0x04, 0x03, 0x15,
- 0x00, 0xa4, 0x03, 0x00, 0x0c, 0xa6, 0x03, 0x00,
- 0x01, 0xa8, 0x03, 0x01, 0x01, 0xaa, 0x03, 0x02,
- 0x01, 0xc0, 0x00, 0xe2, 0xc0, 0x01, 0xe3, 0xc0,
- 0x02, 0xe4, 0x39, 0x88, 0x00, 0x00, 0x00, 0xe0,
- 0x44, 0xd5, 0x00, 0x00, 0x00, 0x29, 0xa0, 0x03,
- 0x01, 0x04, 0x01, 0x00, 0x09, 0x24, 0x0d, 0x43,
- 0x06, 0x01, 0xa6, 0x03, 0x01, 0x00, 0x01, 0x04,
- 0x00, 0x00, 0x15, 0x01, 0xac, 0x03, 0x00, 0x01,
- 0x00, 0x39, 0xd7, 0x00, 0x00, 0x00, 0x43, 0xd8,
- 0x00, 0x00, 0x00, 0x04, 0xd9, 0x00, 0x00, 0x00,
- 0xd1, 0x9f, 0x24, 0x01, 0x00, 0x29, 0xa0, 0x03,
- 0x05, 0x02, 0x03, 0x67, 0x0d, 0x43, 0x06, 0x01,
- 0xa8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
- 0x01, 0x00, 0x29, 0xa0, 0x03, 0x08, 0x00, 0x0d,
- 0x43, 0x06, 0x01, 0xaa, 0x03, 0x03, 0x00, 0x03,
- 0x05, 0x03, 0x00, 0x2a, 0x03, 0xb4, 0x03, 0x00,
- 0x01, 0x00, 0xb6, 0x03, 0x00, 0x01, 0x00, 0xb8,
- 0x03, 0x00, 0x01, 0x00, 0xa6, 0x03, 0x01, 0x00,
+ 0x6f, 0x6f, 0x70, 0x14, 0x75, 0x6e, 0x74, 0x6f,
+ 0x6b, 0x65, 0x6e, 0x69, 0x7a, 0x65, 0x12, 0x69,
+ 0x6e, 0x66, 0x65, 0x72, 0x41, 0x72, 0x67, 0x73,
+ 0x16, 0x64, 0x65, 0x66, 0x69, 0x6e, 0x69, 0x74,
+ 0x69, 0x6f, 0x6e, 0x73, 0x0c, 0x72, 0x65, 0x6e,
+ 0x64, 0x65, 0x72, 0x16, 0x72, 0x65, 0x6e, 0x64,
+ 0x65, 0x72, 0x42, 0x61, 0x74, 0x63, 0x68, 0x06,
+ 0x6d, 0x73, 0x67, 0x0e, 0x63, 0x6f, 0x6e, 0x73,
+ 0x6f, 0x6c, 0x65, 0x06, 0x6c, 0x6f, 0x67, 0x1e,
+ 0x4b, 0x61, 0x54, 0x65, 0x58, 0x20, 0x77, 0x61,
+ 0x72, 0x6e, 0x69, 0x6e, 0x67, 0x3a, 0x20, 0x0a,
+ 0x6d, 0x61, 0x63, 0x72, 0x6f, 0x06, 0x74, 0x65,
+ 0x78, 0x02, 0x69, 0x08, 0x74, 0x65, 0x78, 0x74,
+ 0x0e, 0x6e, 0x75, 0x6d, 0x41, 0x72, 0x67, 0x73,
+ 0x0c, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x0c,
+ 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x73, 0x08, 0x74,
+ 0x65, 0x73, 0x74, 0x02, 0x20, 0x02, 0x23, 0x1e,
+ 0x5c, 0x40, 0x66, 0x69, 0x72, 0x73, 0x74, 0x6f,
+ 0x66, 0x74, 0x77, 0x6f, 0x7b, 0x7d, 0x7b, 0x02,
+ 0x7d, 0x10, 0x73, 0x74, 0x72, 0x69, 0x70, 0x70,
+ 0x65, 0x64, 0x0e, 0x72, 0x65, 0x70, 0x6c, 0x61,
+ 0x63, 0x65, 0x0e, 0x69, 0x6e, 0x64, 0x65, 0x78,
+ 0x4f, 0x66, 0x0c, 0x6d, 0x61, 0x63, 0x72, 0x6f,
+ 0x73, 0x0c, 0x62, 0x65, 0x66, 0x6f, 0x72, 0x65,
+ 0x08, 0x64, 0x65, 0x66, 0x73, 0x16, 0x64, 0x69,
+ 0x73, 0x70, 0x6c, 0x61, 0x79, 0x4d, 0x6f, 0x64,
+ 0x65, 0x10, 0x77, 0x61, 0x72, 0x6e, 0x69, 0x6e,
+ 0x67, 0x73, 0x0e, 0x6f, 0x70, 0x74, 0x69, 0x6f,
+ 0x6e, 0x73, 0x10, 0x73, 0x65, 0x74, 0x74, 0x69,
+ 0x6e, 0x67, 0x73, 0x0c, 0x72, 0x65, 0x70, 0x6f,
+ 0x72, 0x74, 0x0c, 0x72, 0x65, 0x73, 0x75, 0x6c,
+ 0x74, 0x02, 0x65, 0x0a, 0x70, 0x61, 0x72, 0x73,
+ 0x65, 0x18, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74,
+ 0x45, 0x72, 0x72, 0x6f, 0x72, 0x73, 0x18, 0x74,
+ 0x68, 0x72, 0x6f, 0x77, 0x4f, 0x6e, 0x45, 0x72,
+ 0x72, 0x6f, 0x72, 0x16, 0x67, 0x6c, 0x6f, 0x62,
+ 0x61, 0x6c, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x1c,
+ 0x72, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x54, 0x6f,
+ 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x0c, 0x61,
+ 0x73, 0x73, 0x69, 0x67, 0x6e, 0x08, 0x68, 0x74,
+ 0x6d, 0x6c, 0x14, 0x50, 0x61, 0x72, 0x73, 0x65,
+ 0x45, 0x72, 0x72, 0x6f, 0x72, 0x14, 0x72, 0x61,
+ 0x77, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+ 0x10, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f,
+ 0x6e, 0x0a, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x12,
+ 0x73, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x69, 0x66,
+ 0x79, 0x0a, 0x69, 0x74, 0x65, 0x6d, 0x73, 0x06,
+ 0x6d, 0x61, 0x70, 0x08, 0x69, 0x74, 0x65, 0x6d,
+ 0x0e, 0xa0, 0x03, 0x01, 0xa2, 0x03, 0x00, 0x00,
+ 0x01, 0x00, 0x2c, 0x00, 0x0d, 0x00, 0x06, 0x01,
+ 0x9e, 0x01, 0x00, 0x00, 0x00, 0x02, 0x08, 0x07,
+ 0x38, 0x00, 0xa4, 0x03, 0x00, 0x0c, 0xa6, 0x03,
+ 0x00, 0x01, 0xa8, 0x03, 0x01, 0x01, 0xaa, 0x03,
+ 0x02, 0x01, 0xac, 0x03, 0x03, 0x01, 0xae, 0x03,
+ 0x04, 0x01, 0xb0, 0x03, 0x05, 0x01, 0xb2, 0x03,
+ 0x06, 0x01, 0xc0, 0x00, 0xe2, 0xc0, 0x01, 0xe3,
+ 0xc0, 0x02, 0xe4, 0xc0, 0x03, 0x60, 0x04, 0x00,
+ 0xc0, 0x04, 0x60, 0x05, 0x00, 0xc0, 0x05, 0x60,
+ 0x06, 0x00, 0xc0, 0x06, 0x60, 0x07, 0x00, 0x39,
+ 0x88, 0x00, 0x00, 0x00, 0x5f, 0x06, 0x00, 0x44,
+ 0xd8, 0x00, 0x00, 0x00, 0x39, 0x88, 0x00, 0x00,
+ 0x00, 0x5f, 0x07, 0x00, 0x44, 0xd9, 0x00, 0x00,
+ 0x00, 0x29, 0xa0, 0x03, 0x01, 0x06, 0x01, 0x00,
+ 0x1d, 0x9e, 0x02, 0x44, 0x0d, 0x43, 0x06, 0x01,
+ 0xa6, 0x03, 0x01, 0x00, 0x01, 0x04, 0x00, 0x00,
+ 0x15, 0x01, 0xb4, 0x03, 0x00, 0x01, 0x00, 0x39,
+ 0xdb, 0x00, 0x00, 0x00, 0x43, 0xdc, 0x00, 0x00,
+ 0x00, 0x04, 0xdd, 0x00, 0x00, 0x00, 0xd1, 0x9f,
+ 0x24, 0x01, 0x00, 0x29, 0xa0, 0x03, 0x05, 0x02,
+ 0x03, 0x67, 0x0d, 0x43, 0x06, 0x01, 0xa8, 0x03,
+ 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+ 0x29, 0xa0, 0x03, 0x08, 0x00, 0x0d, 0x43, 0x06,
+ 0x01, 0xaa, 0x03, 0x01, 0x06, 0x01, 0x03, 0x01,
+ 0x02, 0xc9, 0x01, 0x07, 0xbc, 0x03, 0x00, 0x01,
+ 0x00, 0xbe, 0x03, 0x01, 0x00, 0x40, 0xc0, 0x03,
+ 0x02, 0x04, 0x40, 0xc2, 0x03, 0x03, 0x02, 0x60,
+ 0xc4, 0x03, 0x01, 0x01, 0x60, 0xc6, 0x03, 0x07,
+ 0x04, 0x40, 0xc0, 0x03, 0x08, 0x05, 0x40, 0xac,
+ 0x03, 0x04, 0x00, 0x62, 0x03, 0x00, 0x62, 0x00,
+ 0x00, 0xc1, 0xc9, 0x62, 0x01, 0x00, 0xd1, 0x42,
+ 0xe4, 0x00, 0x00, 0x00, 0xe9, 0xb6, 0xa0, 0xca,
+ 0x63, 0x01, 0x00, 0xb5, 0xa8, 0xea, 0x4a, 0x62,
+ 0x02, 0x00, 0xd1, 0x42, 0xe4, 0x00, 0x00, 0x00,
+ 0x63, 0x01, 0x00, 0x48, 0x42, 0xe1, 0x00, 0x00,
+ 0x00, 0xcb, 0x63, 0x00, 0x00, 0x63, 0x02, 0x00,
+ 0x9f, 0x11, 0x64, 0x00, 0x00, 0x0e, 0xbf, 0x00,
+ 0xbf, 0x01, 0x33, 0x43, 0xe5, 0x00, 0x00, 0x00,
+ 0x63, 0x02, 0x00, 0x24, 0x01, 0x00, 0xea, 0x0f,
+ 0x63, 0x00, 0x00, 0x04, 0xe6, 0x00, 0x00, 0x00,
+ 0x9f, 0x11, 0x64, 0x00, 0x00, 0x0e, 0x63, 0x01,
+ 0x00, 0x92, 0x64, 0x01, 0x00, 0x0e, 0xec, 0xb1,
+ 0xd1, 0x42, 0xe2, 0x00, 0x00, 0x00, 0x11, 0xeb,
+ 0x03, 0x0e, 0xb5, 0xcc, 0xdd, 0x63, 0x00, 0x00,
+ 0xef, 0x63, 0x03, 0x00, 0xa5, 0xea, 0x4a, 0x62,
+ 0x04, 0x00, 0xc1, 0xc3, 0x04, 0x62, 0x05, 0x00,
+ 0xb6, 0xc3, 0x05, 0x63, 0x05, 0x00, 0x63, 0x03,
+ 0x00, 0xa6, 0xea, 0x1d, 0x63, 0x04, 0x00, 0x04,
+ 0xe7, 0x00, 0x00, 0x00, 0x63, 0x05, 0x00, 0x9f,
+ 0x9f, 0x11, 0x64, 0x04, 0x00, 0x0e, 0x63, 0x05,
+ 0x00, 0x93, 0x64, 0x05, 0x00, 0x0e, 0xec, 0xdc,
+ 0x04, 0xe8, 0x00, 0x00, 0x00, 0x63, 0x04, 0x00,
+ 0x9f, 0x04, 0xe9, 0x00, 0x00, 0x00, 0x9f, 0x63,
+ 0x00, 0x00, 0x9f, 0x11, 0x64, 0x00, 0x00, 0x0e,
+ 0x63, 0x00, 0x00, 0x28, 0xa0, 0x03, 0x12, 0x0f,
+ 0x21, 0x0d, 0x76, 0x53, 0x3f, 0x5d, 0x4a, 0x35,
+ 0x3f, 0x49, 0x12, 0x4e, 0x5d, 0x35, 0x7c, 0x07,
+ 0x1c, 0x5e, 0x5c, 0x5c, 0x5b, 0x61, 0x2d, 0x7a,
+ 0x41, 0x2d, 0x5a, 0x40, 0x5d, 0x2b, 0x24, 0x07,
+ 0x72, 0x00, 0x01, 0x00, 0x32, 0x00, 0x00, 0x00,
+ 0x08, 0x06, 0x00, 0x00, 0x00, 0x04, 0x07, 0xf5,
+ 0xff, 0xff, 0xff, 0x0b, 0x00, 0x05, 0x01, 0x5c,
+ 0x00, 0x1c, 0x0c, 0x00, 0x00, 0x00, 0x01, 0x00,
+ 0x00, 0x00, 0xff, 0xff, 0xff, 0x7f, 0x01, 0x00,
+ 0x00, 0x00, 0x15, 0x02, 0x00, 0x40, 0x00, 0x5a,
+ 0x00, 0x61, 0x00, 0x7a, 0x00, 0x0a, 0x06, 0x0c,
+ 0x00, 0x0a, 0x0d, 0x43, 0x06, 0x01, 0xac, 0x03,
+ 0x01, 0x02, 0x01, 0x05, 0x00, 0x02, 0x40, 0x03,
+ 0xbe, 0x03, 0x00, 0x01, 0x00, 0xd4, 0x03, 0x01,
+ 0x00, 0x60, 0xc4, 0x03, 0x01, 0x01, 0x40, 0x62,
+ 0x01, 0x00, 0x62, 0x00, 0x00, 0xd1, 0x43, 0xeb,
+ 0x00, 0x00, 0x00, 0xbf, 0x00, 0xbf, 0x01, 0x33,
+ 0xc1, 0x24, 0x02, 0x00, 0xc9, 0xb5, 0xca, 0x63,
+ 0x00, 0x00, 0x43, 0xec, 0x00, 0x00, 0x00, 0x04,
+ 0xe7, 0x00, 0x00, 0x00, 0x63, 0x01, 0x00, 0xb6,
+ 0x9f, 0x9f, 0x24, 0x01, 0x00, 0xb4, 0xae, 0xea,
+ 0x0b, 0x63, 0x01, 0x00, 0x93, 0x64, 0x01, 0x00,
+ 0x0e, 0xec, 0xdd, 0x63, 0x01, 0x00, 0x28, 0xa0,
+ 0x03, 0x28, 0x06, 0x21, 0x53, 0x0d, 0x85, 0x2b,
+ 0x0d, 0x07, 0x04, 0x23, 0x23, 0x07, 0x3a, 0x01,
+ 0x01, 0x00, 0x16, 0x00, 0x00, 0x00, 0x08, 0x06,
+ 0x00, 0x00, 0x00, 0x04, 0x07, 0xf5, 0xff, 0xff,
+ 0xff, 0x0b, 0x00, 0x01, 0x23, 0x00, 0x01, 0x23,
+ 0x00, 0x0c, 0x00, 0x0a, 0x0d, 0x43, 0x06, 0x01,
+ 0xae, 0x03, 0x02, 0x04, 0x02, 0x05, 0x01, 0x00,
+ 0xa7, 0x01, 0x06, 0xda, 0x03, 0x00, 0x01, 0x00,
+ 0xdc, 0x03, 0x00, 0x01, 0x00, 0xde, 0x03, 0x01,
+ 0x00, 0x40, 0x6a, 0x02, 0x01, 0x60, 0xbc, 0x03,
+ 0x03, 0x02, 0x60, 0x6a, 0x0a, 0x01, 0x60, 0xaa,
+ 0x03, 0x03, 0x00, 0x62, 0x00, 0x00, 0x39, 0x44,
+ 0x00, 0x00, 0x00, 0xc9, 0x62, 0x01, 0x00, 0xd1,
+ 0x7d, 0xec, 0x65, 0xca, 0x62, 0x02, 0x00, 0xd1,
+ 0x63, 0x01, 0x00, 0x48, 0xcb, 0x63, 0x02, 0x00,
+ 0xd2, 0x63, 0x01, 0x00, 0x48, 0xad, 0xeb, 0x50,
+ 0x63, 0x02, 0x00, 0x99, 0x04, 0x47, 0x00, 0x00,
+ 0x00, 0xad, 0xea, 0x1b, 0x63, 0x00, 0x00, 0x11,
+ 0xeb, 0x03, 0x0e, 0x0b, 0x11, 0x64, 0x00, 0x00,
+ 0x0e, 0x63, 0x00, 0x00, 0x63, 0x01, 0x00, 0x72,
+ 0x63, 0x02, 0x00, 0x4a, 0xec, 0x2a, 0x63, 0x02,
+ 0x00, 0xea, 0x25, 0x63, 0x02, 0x00, 0x42, 0xe4,
+ 0x00, 0x00, 0x00, 0xea, 0x1b, 0x63, 0x00, 0x00,
+ 0x11, 0xeb, 0x03, 0x0e, 0x0b, 0x11, 0x64, 0x00,
+ 0x00, 0x0e, 0x63, 0x00, 0x00, 0x63, 0x01, 0x00,
+ 0x72, 0xdd, 0x63, 0x02, 0x00, 0xef, 0x4a, 0x80,
+ 0xea, 0x9a, 0x0e, 0x0e, 0x62, 0x03, 0x00, 0xd2,
+ 0x7d, 0xec, 0x1f, 0xcc, 0x63, 0x03, 0x00, 0xd1,
+ 0xaa, 0x98, 0xea, 0x16, 0x63, 0x00, 0x00, 0x11,
+ 0xeb, 0x03, 0x0e, 0x0b, 0x11, 0x64, 0x00, 0x00,
+ 0x0e, 0x63, 0x00, 0x00, 0x63, 0x03, 0x00, 0x07,
+ 0x4a, 0x80, 0xea, 0xe0, 0x0e, 0x0e, 0x63, 0x00,
+ 0x00, 0x28, 0xa0, 0x03, 0x34, 0x12, 0x12, 0x21,
+ 0x3a, 0x21, 0x30, 0x0e, 0x3f, 0x44, 0x3a, 0x58,
+ 0x44, 0x45, 0x1c, 0x2b, 0x2b, 0x44, 0x2c, 0x1c,
+ 0x0d, 0x43, 0x06, 0x01, 0xb0, 0x03, 0x04, 0x05,
+ 0x04, 0x06, 0x04, 0x00, 0xc4, 0x03, 0x09, 0xbe,
+ 0x03, 0x00, 0x01, 0x00, 0xe0, 0x03, 0x00, 0x01,
+ 0x00, 0xe2, 0x03, 0x00, 0x01, 0x00, 0xe4, 0x03,
+ 0x00, 0x01, 0x00, 0xe6, 0x03, 0x01, 0x00, 0x40,
+ 0xe8, 0x03, 0x01, 0x01, 0x60, 0xdc, 0x03, 0x01,
+ 0x02, 0x60, 0xea, 0x03, 0x01, 0x03, 0x40, 0xec,
+ 0x03, 0x05, 0x04, 0x03, 0xa6, 0x03, 0x01, 0x00,
  0xa8, 0x03, 0x02, 0x00, 0xa4, 0x03, 0x00, 0x0c,
- 0x39, 0xd7, 0x00, 0x00, 0x00, 0xd3, 0xea, 0x04,
+ 0xae, 0x03, 0x05, 0x00, 0x62, 0x03, 0x00, 0x62,
+ 0x02, 0x00, 0x62, 0x01, 0x00, 0x62, 0x00, 0x00,
+ 0x39, 0xdb, 0x00, 0x00, 0x00, 0xd3, 0xea, 0x04,
  0xdd, 0xec, 0x02, 0xde, 0x44, 0xd3, 0x00, 0x00,
- 0x00, 0x66, 0x02, 0x00, 0x43, 0xdd, 0x00, 0x00,
- 0x00, 0xd1, 0x0b, 0x09, 0x4d, 0xde, 0x00, 0x00,
- 0x00, 0xd2, 0x4d, 0xdb, 0x00, 0x00, 0x00, 0x25,
- 0x02, 0x00, 0xa0, 0x03, 0x0a, 0x05, 0x03, 0x58,
- 0x35, 0x21, 0x21,
+ 0x00, 0xd4, 0xea, 0x11, 0x39, 0x96, 0x00, 0x00,
+ 0x00, 0x43, 0xf7, 0x00, 0x00, 0x00, 0xd4, 0x24,
+ 0x01, 0x00, 0xec, 0x02, 0x0b, 0xc9, 0x63, 0x00,
+ 0x00, 0x42, 0xf8, 0x00, 0x00, 0x00, 0x98, 0x98,
+ 0x11, 0xea, 0x0b, 0x0e, 0x63, 0x00, 0x00, 0x42,
+ 0xf9, 0x00, 0x00, 0x00, 0x98, 0xca, 0x63, 0x00,
+ 0x00, 0x04, 0xf8, 0x00, 0x00, 0x00, 0x9a, 0x0e,
+ 0x63, 0x00, 0x00, 0x63, 0x00, 0x00, 0x42, 0xf9,
+ 0x00, 0x00, 0x00, 0x98, 0x98, 0x11, 0xeb, 0x05,
+ 0x0e, 0x63, 0x01, 0x00, 0x44, 0xf9, 0x00, 0x00,
+ 0x00, 0x63, 0x00, 0x00, 0xd2, 0x44, 0xf0, 0x00,
+ 0x00, 0x00, 0x63, 0x00, 0x00, 0x42, 0xfa, 0x00,
+ 0x00, 0x00, 0x98, 0xea, 0x1b, 0x63, 0x00, 0x00,
+ 0x42, 0xf9, 0x00, 0x00, 0x00, 0x98, 0xea, 0x10,
+ 0x66, 0x02, 0x00, 0x43, 0xfb, 0x00, 0x00, 0x00,
+ 0xd1, 0x63, 0x00, 0x00, 0x25, 0x02, 0x00, 0x63,
+ 0x00, 0x00, 0x63, 0x00, 0x00, 0x42, 0xed, 0x00,
+ 0x00, 0x00, 0x11, 0xeb, 0x03, 0x0e, 0x0b, 0x44,
+ 0xed, 0x00, 0x00, 0x00, 0x39, 0x8d, 0x00, 0x00,
+ 0x00, 0x43, 0xfc, 0x00, 0x00, 0x00, 0x0b, 0x63,
+ 0x00, 0x00, 0x42, 0xed, 0x00, 0x00, 0x00, 0x24,
+ 0x02, 0x00, 0xcb, 0x0b, 0xcc, 0x6d, 0x1f, 0x00,
+ 0x00, 0x00, 0x63, 0x03, 0x00, 0x66, 0x02, 0x00,
+ 0x43, 0xfb, 0x00, 0x00, 0x00, 0xd1, 0x63, 0x00,
+ 0x00, 0x24, 0x02, 0x00, 0x44, 0xfd, 0x00, 0x00,
+ 0x00, 0x0e, 0xed, 0x85, 0x00, 0xc3, 0x04, 0x6d,
+ 0x7f, 0x00, 0x00, 0x00, 0xc2, 0x04, 0x66, 0x02,
+ 0x00, 0x42, 0xfe, 0x00, 0x00, 0x00, 0xa9, 0x98,
+ 0xea, 0x04, 0xc2, 0x04, 0x2f, 0x63, 0x03, 0x00,
+ 0x0b, 0xc2, 0x04, 0x42, 0xff, 0x00, 0x00, 0x00,
+ 0x4d, 0x33, 0x00, 0x00, 0x00, 0xc2, 0x04, 0x42,
+ 0x00, 0x01, 0x00, 0x00, 0x4d, 0x00, 0x01, 0x00,
+ 0x00, 0xc2, 0x04, 0xe9, 0x4d, 0x30, 0x00, 0x00,
+ 0x00, 0x44, 0x01, 0x01, 0x00, 0x00, 0x63, 0x01,
+ 0x00, 0xea, 0x3a, 0x63, 0x00, 0x00, 0x09, 0x44,
+ 0xf9, 0x00, 0x00, 0x00, 0x63, 0x00, 0x00, 0x39,
+ 0x8d, 0x00, 0x00, 0x00, 0x43, 0xfc, 0x00, 0x00,
+ 0x00, 0x0b, 0x63, 0x02, 0x00, 0x24, 0x02, 0x00,
+ 0x44, 0xed, 0x00, 0x00, 0x00, 0x63, 0x03, 0x00,
+ 0x66, 0x02, 0x00, 0x43, 0xfb, 0x00, 0x00, 0x00,
+ 0xd1, 0x63, 0x00, 0x00, 0x24, 0x02, 0x00, 0x44,
+ 0xfd, 0x00, 0x00, 0x00, 0x0e, 0xec, 0x02, 0x2f,
+ 0x63, 0x00, 0x00, 0x42, 0xfa, 0x00, 0x00, 0x00,
+ 0xea, 0x16, 0x63, 0x03, 0x00, 0xe0, 0x63, 0x00,
+ 0x00, 0x42, 0xed, 0x00, 0x00, 0x00, 0x63, 0x02,
+ 0x00, 0xf0, 0x44, 0xed, 0x00, 0x00, 0x00, 0x63,
+ 0x03, 0x00, 0x42, 0x01, 0x01, 0x00, 0x00, 0x39,
+ 0x44, 0x00, 0x00, 0x00, 0xad, 0xea, 0x1a, 0x63,
+ 0x03, 0x00, 0x42, 0xed, 0x00, 0x00, 0x00, 0x39,
+ 0x44, 0x00, 0x00, 0x00, 0xad, 0xea, 0x0a, 0x63,
+ 0x03, 0x00, 0x42, 0xfd, 0x00, 0x00, 0x00, 0x28,
+ 0x39, 0x96, 0x00, 0x00, 0x00, 0x43, 0x02, 0x01,
+ 0x00, 0x00, 0x63, 0x03, 0x00, 0x25, 0x01, 0x00,
+ 0xa0, 0x03, 0x56, 0x1d, 0x3f, 0x58, 0x6c, 0x7b,
+ 0x35, 0x80, 0x30, 0x71, 0x4f, 0x6c, 0x76, 0x0d,
+ 0x1c, 0x76, 0x3a, 0x49, 0x0d, 0x08, 0xd0, 0x1e,
+ 0x30, 0x80, 0x77, 0x17, 0x35, 0x6d, 0xa3, 0x2b,
+ 0x08, 0x0d, 0x43, 0x06, 0x01, 0xb2, 0x03, 0x01,
+ 0x00, 0x01, 0x05, 0x01, 0x01, 0x25, 0x01, 0x86,
+ 0x04, 0x00, 0x01, 0x00, 0xb0, 0x03, 0x06, 0x00,
+ 0x39, 0x96, 0x00, 0x00, 0x00, 0x43, 0x02, 0x01,
+ 0x00, 0x00, 0x39, 0x96, 0x00, 0x00, 0x00, 0x43,
+ 0xf7, 0x00, 0x00, 0x00, 0xd1, 0x24, 0x01, 0x00,
+ 0x43, 0x04, 0x01, 0x00, 0x00, 0xc0, 0x00, 0x24,
+ 0x01, 0x00, 0x25, 0x01, 0x00, 0xa0, 0x03, 0x82,
+ 0x01, 0x04, 0x03, 0x00, 0x1d, 0x12, 0x0d, 0x43,
+ 0x06, 0x01, 0x00, 0x01, 0x01, 0x01, 0x06, 0x01,
+ 0x00, 0x38, 0x02, 0x8a, 0x04, 0x00, 0x01, 0x00,
+ 0xec, 0x03, 0x03, 0x00, 0x03, 0xb0, 0x03, 0x00,
+ 0x00, 0x6d, 0x22, 0x00, 0x00, 0x00, 0xdd, 0xd1,
+ 0x42, 0xdf, 0x00, 0x00, 0x00, 0xd1, 0x42, 0xf0,
+ 0x00, 0x00, 0x00, 0xd1, 0x42, 0xf1, 0x00, 0x00,
+ 0x00, 0xd1, 0x42, 0xf2, 0x00, 0x00, 0x00, 0x22,
+ 0x04, 0x00, 0x0f, 0x28, 0xc9, 0x6d, 0x12, 0x00,
+ 0x00, 0x00, 0xc5, 0x39, 0xc1, 0x00, 0x00, 0x00,
+ 0xa9, 0xea, 0x03, 0xc5, 0x2f, 0x07, 0x0f, 0x28,
+ 0x2f, 0xa0, 0x03, 0x83, 0x01, 0x08, 0x03, 0x1c,
+ 0x94, 0x26, 0x30, 0x08, 0x08, 0x0d,
 };
 
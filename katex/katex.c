@@ -7,32 +7,87 @@
 
 #include "katex.bytecode.h"
 
-typedef struct State {
+struct State {
     JSRuntime *rt;
     JSContext *ctx;
     JSAtom render;
+    JSAtom render_batch;
     JSValue global_obj;
     JSValue true_val;
     JSValue false_val;
-} State;
+    // interrupt, if not null, is set to nonzero by another thread to abort the
+    // current call to render.
+    const int *interrupt;
+    // limits_generation is the value of the global limits_generation when the
+    // limits were last applied to the runtime.
+    int limits_generation;
+};
 
 typedef struct RenderArgs {
     JSValue tex;
     JSValue display_mode;
     JSValue warnings;
+    JSValue options;
 } RenderArgs;
 
-// cgo only uses gcc and clang, so __thread portability is not an issue.
-__thread State *tls_state = 0;
+// Limits applied to all runtimes. Runtimes apply them before rendering if
+// limits_generation has changed.
+static size_t memory_limit = -1;
+static size_t stack_limit = JS_DEFAULT_STACK_SIZE;
+static int limits_generation = 0;
 
-static State *init_qjs()
+void set_limits(size_t memory, size_t stack)
 {
-    if (tls_state) {
-        return tls_state;
+    __atomic_store_n(&memory_limit, memory ? memory : (size_t)-1, __ATOMIC_RELAXED);
+    __atomic_store_n(&stack_limit, stack ? stack : JS_DEFAULT_STACK_SIZE, __ATOMIC_RELAXED);
+    __atomic_add_fetch(&limits_generation, 1, __ATOMIC_RELEASE);
+}
+
+static void apply_limits(State *state)
+{
+    int generation = __atomic_load_n(&limits_generation, __ATOMIC_ACQUIRE);
+    if (generation != state->limits_generation) {
+        JS_SetMemoryLimit(state->rt, __atomic_load_n(&memory_limit, __ATOMIC_RELAXED));
+        JS_SetMaxStackSize(state->ctx, __atomic_load_n(&stack_limit, __ATOMIC_RELAXED));
+        state->limits_generation = generation;
     }
+}
+
+// Returns the error code for the pending exception, and clears it.
+static size_t exception_error(JSContext *ctx)
+{
+    size_t result = Error_BadInput;
+    JSValue exception = JS_GetException(ctx);
+    const char *message = JS_ToCString(ctx, exception);
+    // When out of memory, QuickJS may fail to allocate the error it throws, in
+    // which case the exception is null.
+    if (JS_IsNull(exception) || message == 0 || strstr(message, "out of memory")) {
+        result = Error_OutOfMemory;
+    } else if (strstr(message, "stack overflow")) {
+        result = Error_StackOverflow;
+    }
+    JS_FreeCString(ctx, message);
+    JS_FreeValue(ctx, exception);
+    return -result;
+}
+
+static int interrupt_handler(JSRuntime *rt, void *opaque)
+{
+    State *state = opaque;
+    return state->interrupt && __atomic_load_n(state->interrupt, __ATOMIC_RELAXED);
+}
 
+State *new_state(void)
+{
     JSRuntime *rt = JS_NewRuntime();
+    if (rt == 0) {
+        return 0;
+    }
     JSContext *ctx = JS_NewContextRaw(rt);
+    if (ctx == 0) {
+        JS_FreeRuntime(rt);
+        return 0;
+    }
     JS_AddIntrinsicBaseObjects(ctx);
     JS_AddIntrinsicRegExp(ctx);
     JS_AddIntrinsicJSON(ctx);
@@ -44,21 +99,37 @@ static State *init_qjs()
     js_std_eval_binary(ctx, qjsc_katex, qjsc_katex_size, 1);
     js_std_eval_binary(ctx, qjsc_api, qjsc_api_size, 0);
 
-    tls_state = calloc(sizeof(State), 1);
-    tls_state->rt = rt;
-    tls_state->ctx = ctx;
-    tls_state->render = JS_NewAtom(ctx, "render");
-    tls_state->global_obj = JS_GetGlobalObject(ctx);
-    tls_state->false_val = JS_NewBool(ctx, false);
-    tls_state->true_val = JS_NewBool(ctx, true);
+    State *state = calloc(sizeof(State), 1);
+    state->rt = rt;
+    state->ctx = ctx;
+    state->render = JS_NewAtom(ctx, "render");
+    state->render_batch = JS_NewAtom(ctx, "renderBatch");
+    state->global_obj = JS_GetGlobalObject(ctx);
+    state->false_val = JS_NewBool(ctx, false);
+    state->true_val = JS_NewBool(ctx, true);
+    JS_SetInterruptHandler(rt, interrupt_handler, state);
+
+    return state;
+}
 
-    return tls_state;
+void free_state(State *state)
+{
+    JSContext *ctx = state->ctx;
+    JS_FreeAtom(ctx, state->render);
+    JS_FreeAtom(ctx, state->render_batch);
+    JS_FreeValue(ctx, state->global_obj);
+    JS_FreeContext(ctx);
+    JS_FreeRuntime(state->rt);
+    free(state);
 }
 
-size_t render(void *dest, size_t dest_cap, void *src, size_t src_len, Mode mode)
+size_t render(State *state, void *dest, size_t dest_cap, void *src, size_t src_len, Mode mode, void *opts, size_t opts_len, const int *interrupt)
 {
-    State *state = init_qjs();
     JSContext *ctx = state->ctx;
+    // The state may have been used on another thread, with another stack.
+    JS_UpdateStackTop(ctx);
+    state->interrupt = interrupt;
+    apply_limits(state);
 
     size_t dest_len = 0;
     const char *buf = 0;
@@ -67,15 +138,22 @@ size_t render(void *dest, size_t dest_cap, void *src, size_t src_len, Mode mode)
     args.tex = JS_NewStringLen(ctx, src, src_len);
     args.display_mode = (mode & Mode_Display) ? state->true_val : state->false_val;
     args.warnings = (mode & Mode_Warn) ? state->true_val : state->false_val;
-    JSValue v = JS_Invoke(ctx, state->global_obj, state->render, 3, &args.tex);
+    args.options = opts_len ? JS_NewStringLen(ctx, opts, opts_len) : JS_UNDEFINED;
+    JSValue v = JS_Invoke(ctx, state->global_obj, state->render, 4, &args.tex);
 
     if (JS_IsString(v) == false) {
-        dest_len = -1;
+        dest_len = JS_IsException(v) ? exception_error(ctx) : -Error_BadInput;
         goto done;
     }
 
     buf = JS_ToCStringLen(ctx, &dest_len, v);
 
+    if (buf == 0) {
+        // The conversion can run out of memory under the limits.
+        dest_len = exception_error(ctx);
+        goto done;
+    }
+
     if (dest_len > dest_cap) {
         // QJS strings are not UTF-8, so although we can usually tell the buffer
         // is too small to use before converting, we don't know how long it will
@@ -87,9 +165,58 @@ size_t render(void *dest, size_t dest_cap, void *src, size_t src_len, Mode mode)
     memcpy(dest, buf, dest_len);
 
 done:
+    state->interrupt = 0;
     JS_FreeValue(ctx, args.tex);
+    JS_FreeValue(ctx, args.options);
     JS_FreeValue(ctx, v);
     JS_FreeCString(ctx, buf);
 
     return dest_len;
 }
+
+const char *render_batch(State *state, void *src, size_t src_len, size_t *len, const int *interrupt)
+{
+    JSContext *ctx = state->ctx;
+    JS_UpdateStackTop(ctx);
+    state->interrupt = interrupt;
+    apply_limits(state);
+
+    const char *result = 0;
+
+    JSValue items = JS_NewStringLen(ctx, src, src_len);
+    JSValue v = JS_Invoke(ctx, state->global_obj, state->render_batch, 1, &items);
+
+    if (JS_IsException(v)) {
+        *len = exception_error(ctx);
+    } else if (JS_IsString(v) == false) {
+        *len = -Error_BadInput;
+    } else {
+        // Unlike render, the result is kept rather than copied, so the batch is
+        // never rendered twice to find its length.
+        result = JS_ToCStringLen(ctx, len, v);
+        if (result == 0) {
+            *len = exception_error(ctx);
+        }
+    }
+
+    state->interrupt = 0;
+    JS_FreeValue(ctx, items);
+    JS_FreeValue(ctx, v);
+
+    return result;
+}
+
+void free_result(State *state, const char *result)
+{
+    JS_FreeCString(state->ctx, result);
+}
+
+const uint8_t *bytecode(int module, size_t *len)
+{
+    if (module == 0) {
+        *len = qjsc_katex_size;
+        return qjsc_katex;
+    }
+    *len = qjsc_api_size;
+    return qjsc_api;
+}
@@ -1,7 +1,8 @@
 // Package katex exposes a simplified API to KaTeX, run on QuickJS.
 //
 // Exported functions are thread-safe and can be called from any goroutine at
-// any time. Use it like this if you're doing a lot of TeX rendering:
+// any time. TeX is rendered by a bounded pool of QuickJS runtimes, see Pool.
+// Use it like this if you're doing a lot of TeX rendering:
 // 	// var dest, src []byte
 // 	err := katex.Render(&dest, src, katex.Inline)
 //
@@ -24,8 +25,16 @@ package katex
 import "C"
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -35,15 +44,78 @@ var ErrTooLarge = errors.New("KaTeX input too large")
 
 // ErrBadInput indicates an internal KaTeX error, possibly due to differences
 // between QuickJS and browser runtimes. These do not represent parse errors,
-// which are rendered.
+// which are rendered, or returned as a *ParseError.
 var ErrBadInput = errors.New("bad KaTeX input")
 
 // ErrInconsistent indicates that equivalent calls into the qjs returned
 // different results. This almost certainly means the qjs runtime internal state
-// has been corrupted. This has never been observed, so methods to detect this
-// and recover are not implemented.
+// has been corrupted. This has never been observed. The runtime is replaced, so
+// later calls are unaffected.
 var ErrInconsistent = errors.New("inconsistent results between calls into qjs")
 
+// ErrOutOfMemory indicates that rendering exceeded the memory limit set by
+// SetLimits.
+var ErrOutOfMemory = errors.New("KaTeX out of memory")
+
+// ErrStackOverflow indicates that rendering exceeded the stack limit set by
+// SetLimits.
+var ErrStackOverflow = errors.New("KaTeX stack overflow")
+
+// ErrTimeout indicates that rendering was stopped because the context passed to
+// RenderContext was done, e.g., because its deadline passed.
+var ErrTimeout = errors.New("KaTeX rendering timed out")
+
+// ParseError is a TeX parse error reported by KaTeX. These are only returned
+// when rendering with Options.ThrowOnError or the Throw mode flag; otherwise,
+// KaTeX renders an error message instead.
+type ParseError struct {
+	// Message is KaTeX's description of the error.
+	Message string
+	// Position is the byte offset in the TeX where the error occurred, or -1 if
+	// KaTeX did not report one.
+	Position int
+	// Length is the number of bytes of TeX at Position that caused the error.
+	Length int
+}
+
+func (e *ParseError) Error() string {
+	if e.Position < 0 {
+		return "KaTeX parse error: " + e.Message
+	}
+	return fmt.Sprintf("KaTeX parse error: %s at position %d", e.Message, e.Position+1)
+}
+
+// byteOffset converts an offset in UTF-16 code units, as used by JavaScript
+// strings, to an offset in bytes into src.
+func byteOffset(src []byte, units int) int {
+	offset := 0
+	for units > 0 && offset < len(src) {
+		r, size := utf8.DecodeRune(src[offset:])
+		if r > 0xffff {
+			units -= 2
+		} else {
+			units--
+		}
+		offset += size
+	}
+	return offset
+}
+
+// Version identifies the KaTeX bytecode this package runs. It changes whenever
+// the bytecode is regenerated, e.g., when KaTeX is upgraded, so persistent
+// caches of rendered TeX can use it to invalidate stale results.
+var Version = bytecodeVersion()
+
+func bytecodeVersion() string {
+	h := sha256.New()
+	for module := C.int(0); module < 2; module++ {
+		var n C.size_t
+		code := C.bytecode(module, &n)
+		h.Write(C.GoBytes(unsafe.Pointer(code), C.int(n)))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
 func clen(buf []byte) C.size_t {
 	return C.size_t(len(buf))
 }
@@ -56,7 +128,29 @@ func cref(buf []byte) unsafe.Pointer {
 	if cap(buf) == 0 {
 		return unsafe.Pointer(nil)
 	}
-	return unsafe.Pointer(&buf[0])
+	return unsafe.Pointer(&buf[:cap(buf)][0])
+}
+
+// Limits bounds the resources used by the QuickJS runtimes that render TeX, to
+// protect against untrusted input. TeX that exceeds them fails to render with
+// ErrOutOfMemory or ErrStackOverflow.
+type Limits struct {
+	// Memory is the maximum number of bytes each runtime allocates, including
+	// KaTeX itself, which needs several megabytes. Zero is no limit.
+	Memory int
+	// Stack is the maximum number of bytes of stack used by each runtime. Zero
+	// is QuickJS's default of 256 KiB. Larger values risk overflowing the stacks
+	// of the threads that call into C.
+	Stack int
+}
+
+var limitsMu sync.Mutex
+
+// SetLimits sets the limits of all runtimes, for all following calls.
+func SetLimits(l Limits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	C.set_limits(C.size_t(l.Memory), C.size_t(l.Stack))
 }
 
 // Mode specifies how KaTeX is rendered with flags.
@@ -69,20 +163,27 @@ const (
 	Warn        Mode = 0b10
 	InlineWarn  Mode = Inline | Warn
 	DisplayWarn Mode = Display | Warn
+
+	// Throw makes TeX that fails to parse return a *ParseError, rather than
+	// rendering an error message.
+	Throw Mode = 0b100
 )
 
 func (m Mode) String() string {
-	switch m {
-	case Inline:
-		return "inline"
-	case Display:
-		return "display"
-	case InlineWarn:
-		return "inline|warn"
-	case DisplayWarn:
-		return "display|warn"
+	if m&^(Display|Warn|Throw) != 0 {
+		return "none"
+	}
+	result := "inline"
+	if m&Display != 0 {
+		result = "display"
 	}
-	return "none"
+	if m&Warn != 0 {
+		result += "|warn"
+	}
+	if m&Throw != 0 {
+		result += "|throw"
+	}
+	return result
 }
 
 // Warnings returns a Mode with the warning flag set or unset.
@@ -93,20 +194,113 @@ func Warnings(on bool) Mode {
 	return Mode(0)
 }
 
-func render(dest []byte, src []byte, m C.Mode) ([]byte, error) {
-	if len(src) == 0 {
-		return dest[:0], nil
+// Output selects the markup that KaTeX renders.
+type Output string
+
+// Possible values of Output:
+const (
+	// HTMLAndMathML renders HTML for display and MathML for accessibility. This
+	// is KaTeX's default.
+	HTMLAndMathML Output = "htmlAndMathml"
+	// HTML renders HTML only, which needs the KaTeX stylesheet.
+	HTML Output = "html"
+	// MathML renders MathML only, which does not need the KaTeX stylesheet.
+	MathML Output = "mathml"
+)
+
+// Options configures KaTeX. The zero value is KaTeX's default configuration,
+// rendering inline. See https://katex.org/docs/options for details.
+type Options struct {
+	// DisplayMode renders TeX in display mode, rather than inline.
+	DisplayMode bool `json:"-"`
+	// Warnings allows KaTeX to print warnings to standard out.
+	Warnings bool `json:"-"`
+	// ThrowOnError makes TeX that fails to parse return a *ParseError, rather
+	// than rendering an error message.
+	ThrowOnError bool `json:"throwOnError,omitempty"`
+
+	// Leqno renders display mode equation numbers on the left.
+	Leqno bool `json:"leqno,omitempty"`
+	// Fleqn renders display mode TeX flush left.
+	Fleqn bool `json:"fleqn,omitempty"`
+	// Strict is one of "ignore", "warn" or "error", and controls what KaTeX does
+	// with TeX that is not supported by LaTeX. The default is "warn".
+	Strict string `json:"strict,omitempty"`
+	// Trust allows potentially unsafe commands, e.g., \href and \includegraphics.
+	Trust bool `json:"trust,omitempty"`
+	// Output selects the markup that KaTeX renders. The default is
+	// HTMLAndMathML.
+	Output Output `json:"output,omitempty"`
+	// ErrorColor is the color of TeX that fails to parse. The default is
+	// "#cc0000".
+	ErrorColor string `json:"errorColor,omitempty"`
+	// MinRuleThickness is the minimum thickness of fraction lines, etc., in ems.
+	// Zero is KaTeX's default.
+	MinRuleThickness float64 `json:"minRuleThickness,omitempty"`
+	// MaxSize is the maximum size of user-specified sizes, in ems. Zero is
+	// KaTeX's default, which is unlimited.
+	MaxSize float64 `json:"maxSize,omitempty"`
+	// MaxExpand is the maximum number of macro expansions. Zero is KaTeX's
+	// default, which is 1000.
+	MaxExpand int `json:"maxExpand,omitempty"`
+	// ColorIsTextColor makes \color behave like \textcolor.
+	ColorIsTextColor bool `json:"colorIsTextColor,omitempty"`
+
+	// Macros defines macros. Keys are macro names, including the leading
+	// backslash, and values are their expansions, e.g., "\\R": "\\mathbb{R}".
+	Macros map[string]string `json:"macros,omitempty"`
+	// GlobalGroup renders TeX in the global group. Definitions made by the TeX,
+	// e.g., with \def, \newcommand or \gdef, are added to Macros, so that they
+	// apply to later calls with the same Macros, as with KaTeX's macros option.
+	// As such, Macros must not be used by other goroutines during the call. If
+	// Macros is nil, definitions only apply to the TeX itself. Definitions with delimited parameters, or made by \let to
+	// built-in functions, cannot be represented in Macros, and are lost.
+	GlobalGroup bool `json:"globalGroup,omitempty"`
+}
+
+func (opts *Options) mode() C.Mode {
+	m := Inline
+	if opts.DisplayMode {
+		m |= Display
 	}
-	if len(src) > C.JS_STRING_LEN_MAX {
-		return dest[:0], ErrTooLarge
+	if opts.Warnings {
+		m |= Warn
 	}
-	size := C.render(cref(dest), ccap(dest), cref(src), clen(src), m)
-	if int(size) == -1 {
-		return dest[:0], ErrBadInput
+	return C.Mode(m)
+}
+
+func (m Mode) options() *Options {
+	return &Options{
+		DisplayMode:  m&Display != 0,
+		Warnings:     m&Warn != 0,
+		ThrowOnError: m&Throw != 0,
+	}
+}
+
+// renderError returns the error indicated by the result of C.render, if any.
+func renderError(size C.size_t) error {
+	switch -int(size) {
+	case C.Error_BadInput:
+		return ErrBadInput
+	case C.Error_OutOfMemory:
+		return ErrOutOfMemory
+	case C.Error_StackOverflow:
+		return ErrStackOverflow
+	}
+	return nil
+}
+
+func render(state *C.State, dest []byte, src []byte, m C.Mode, opts []byte, interrupt *C.int) ([]byte, error) {
+	size := C.render(state, cref(dest), ccap(dest), cref(src), clen(src), m, cref(opts), clen(opts), interrupt)
+	if err := renderError(size); err != nil {
+		return dest[:0], err
 	}
 	if size > ccap(dest) {
 		dest = make([]byte, size)
-		newSize := C.render(cref(dest), ccap(dest), cref(src), clen(src), m)
+		newSize := C.render(state, cref(dest), ccap(dest), cref(src), clen(src), m, cref(opts), clen(opts), interrupt)
+		if err := renderError(newSize); err != nil {
+			return dest[:0], err
+		}
 		if size != newSize {
 			return dest[:0], ErrInconsistent
 		}
@@ -119,19 +313,197 @@ func render(dest []byte, src []byte, m C.Mode) ([]byte, error) {
 // The dest slice will be reallocated to fit, if necessary.
 //
 // On error, dest will have its length set to 0 and err will always be one of
-// the errors defined in this package.
+// the errors defined in this package, or a *ParseError.
 func Render(dest *[]byte, src []byte, m Mode) error {
-	var err error
-	*dest, err = render(*dest, src, C.Mode(m))
+	return RenderWithOptions(dest, src, m.options())
+}
+
+// RenderWithOptions is like Render, but with all of KaTeX's options.
+func RenderWithOptions(dest *[]byte, src []byte, opts *Options) error {
+	return RenderContext(context.Background(), dest, src, opts)
+}
+
+// RenderContext is like RenderWithOptions, but stops rendering and returns
+// ErrTimeout as soon as possible after ctx is done. This bounds the time spent
+// on TeX that is expensive to render, e.g., recursive macros, and waiting for a
+// runtime to render with.
+func RenderContext(ctx context.Context, dest *[]byte, src []byte, opts *Options) error {
+	for {
+		// The default pool is closed after it is replaced, so retrying uses the
+		// replacement.
+		err := defaultPool().RenderContext(ctx, dest, src, opts)
+		if err != ErrClosed {
+			return err
+		}
+	}
+}
+
+// RenderReporting is like RenderContext, but also returns the parse error of TeX
+// that fails to parse when opts.ThrowOnError is not set, along with KaTeX's
+// error message rendered in dest, with a single call into KaTeX. When
+// opts.ThrowOnError is set, the parse error is returned as both results.
+func RenderReporting(ctx context.Context, dest *[]byte, src []byte, opts *Options) (*ParseError, error) {
+	for {
+		pe, err := defaultPool().RenderReporting(ctx, dest, src, opts)
+		if err != ErrClosed {
+			return pe, err
+		}
+	}
+}
+
+// watch returns a flag that is set when ctx is done, to interrupt rendering, and
+// a function that stops watching ctx. The flag is nil if ctx is never done.
+func watch(ctx context.Context) (*int32, func()) {
+	done := ctx.Done()
+	if done == nil {
+		return nil, func() {}
+	}
+	interrupt := new(int32)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			atomic.StoreInt32(interrupt, 1)
+		case <-stopped:
+		}
+	}()
+	return interrupt, func() { close(stopped) }
+}
+
+// RenderWithOptions is like the package-level RenderWithOptions, but renders
+// with the runtimes in p.
+func (p *Pool) RenderWithOptions(dest *[]byte, src []byte, opts *Options) error {
+	return p.RenderContext(context.Background(), dest, src, opts)
+}
+
+// RenderContext is like the package-level RenderContext, but renders with the
+// runtimes in p.
+func (p *Pool) RenderContext(ctx context.Context, dest *[]byte, src []byte, opts *Options) error {
+	_, err := p.render(ctx, dest, src, opts, false)
 	return err
 }
 
+// RenderReporting is like the package-level RenderReporting, but renders with
+// the runtimes in p.
+func (p *Pool) RenderReporting(ctx context.Context, dest *[]byte, src []byte, opts *Options) (*ParseError, error) {
+	return p.render(ctx, dest, src, opts, true)
+}
+
+// reportingOptions encodes as opts with reportErrors set, which makes KaTeX
+// report parse errors that it renders.
+type reportingOptions struct {
+	*Options
+	ReportErrors bool `json:"reportErrors"`
+}
+
+func (p *Pool) render(ctx context.Context, dest *[]byte, src []byte, opts *Options, report bool) (*ParseError, error) {
+	if len(src) == 0 {
+		*dest = (*dest)[:0]
+		return nil, nil
+	}
+	if len(src) > C.JS_STRING_LEN_MAX {
+		*dest = (*dest)[:0]
+		return nil, ErrTooLarge
+	}
+	if ctx.Err() != nil {
+		*dest = (*dest)[:0]
+		return nil, ErrTimeout
+	}
+	interrupt, stop := watch(ctx)
+	defer stop()
+	rt, err := p.get(ctx)
+	if err != nil {
+		*dest = (*dest)[:0]
+		return nil, err
+	}
+	var encoded []byte
+	if report {
+		encoded, _ = json.Marshal(&reportingOptions{Options: opts, ReportErrors: true})
+	} else {
+		encoded, _ = json.Marshal(opts)
+	}
+	*dest, err = render(rt.state, *dest, src, opts.mode(), encoded, (*C.int)(unsafe.Pointer(interrupt)))
+	var pe *ParseError
+	if err == nil && len(*dest) > 0 && (*dest)[0] == '{' {
+		*dest, pe, err = decodeResult(*dest, src, opts)
+	}
+	// After decoding, so that the runtime is replaced if its result was bad.
+	p.put(rt, err)
+	if err != nil && interrupt != nil && atomic.LoadInt32(interrupt) != 0 {
+		return nil, ErrTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pe != nil && opts.ThrowOnError {
+		*dest = (*dest)[:0]
+		return pe, pe
+	}
+	return pe, nil
+}
+
+// RenderWithMacros is like Render, but with macros defined as by
+// Options.Macros. Definitions made by src are not added to macros.
+func RenderWithMacros(dest *[]byte, src []byte, m Mode, macros map[string]string) error {
+	opts := m.options()
+	opts.Macros = macros
+	return RenderWithOptions(dest, src, opts)
+}
+
+// RenderGlobal is like RenderWithMacros, but renders src in the global group, as
+// by Options.GlobalGroup, so definitions made by src are added to macros.
+func RenderGlobal(dest *[]byte, src []byte, m Mode, macros map[string]string) error {
+	opts := m.options()
+	opts.Macros = macros
+	opts.GlobalGroup = true
+	return RenderWithOptions(dest, src, opts)
+}
+
+// decodeResult decodes the JSON object KaTeX returns when rendering with
+// globalGroup set and definitions made, or with throwOnError or reportErrors
+// set and a parse error. Definitions are added to opts.Macros, and the HTML, if
+// any, is returned in dest along with the parse error.
+func decodeResult(dest []byte, src []byte, opts *Options) ([]byte, *ParseError, error) {
+	var result struct {
+		HTML   string             `json:"html"`
+		Macros map[string]*string `json:"macros"`
+		Error  *struct {
+			Message  string `json:"message"`
+			Position *int   `json:"position"`
+			Length   int    `json:"length"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(dest, &result); err != nil {
+		return dest[:0], nil, ErrBadInput
+	}
+	for name, macro := range result.Macros {
+		if opts.Macros == nil {
+			break
+		}
+		if macro == nil {
+			delete(opts.Macros, name)
+		} else {
+			opts.Macros[name] = *macro
+		}
+	}
+	var pe *ParseError
+	if e := result.Error; e != nil {
+		pe = &ParseError{Message: e.Message, Position: -1}
+		if e.Position != nil {
+			pe.Position = byteOffset(src, *e.Position)
+			pe.Length = byteOffset(src[pe.Position:], e.Length)
+		}
+	}
+	return append(dest[:0], result.HTML...), pe, nil
+}
+
 // RenderTo renders a TeX string to HTML with KaTeX.
 //
-// On error, err will always be one of the errors defined in this package.
+// On error, err will always be one of the errors defined in this package, or a
+// *ParseError.
 func RenderTo(w io.Writer, src []byte, m Mode) error {
-	size := len(src) * 150
-	dest, err := render(make([]byte, size), src, C.Mode(m))
+	dest := make([]byte, len(src)*150)
+	err := RenderWithOptions(&dest, src, m.options())
 	if err == nil {
 		w.Write(dest)
 	}
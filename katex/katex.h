@@ -12,7 +12,43 @@ typedef enum Mode
     Mode_Warn = Mode_InlineWarn
 } Mode;
 
-// Returns length of resulting string on sucess, -1 on failure. If the length is
-// too large to fit in the destination buffer, no bytes will be written, but the
-// length that would have been written otherwise is returned.
-size_t render(void *dest, size_t dest_cap, void *src, size_t src_len, Mode mode);
+// Errors returned by render, negated.
+typedef enum Error
+{
+    Error_BadInput = 1,
+    Error_OutOfMemory = 2,
+    Error_StackOverflow = 3,
+} Error;
+
+// State is a QuickJS runtime with KaTeX loaded. It must only be used by one
+// thread at a time.
+typedef struct State State;
+
+// Returns a new state, or null if out of memory.
+State *new_state(void);
+
+// Frees a state returned by new_state.
+void free_state(State *state);
+
+// Sets the limits of all runtimes. Zero restores the default, which is no
+// memory limit and QuickJS's default stack size.
+void set_limits(size_t memory, size_t stack);
+
+// Returns length of resulting string on sucess, or a negated Error on failure.
+// If the length is too large to fit in the destination buffer, no bytes will be
+// written, but the length that would have been written otherwise is returned. opts is a JSON
+// object of KaTeX options, or empty. If interrupt is not null, setting it to
+// nonzero from another thread makes render fail as soon as possible.
+size_t render(State *state, void *dest, size_t dest_cap, void *src, size_t src_len, Mode mode, void *opts, size_t opts_len, const int *interrupt);
+
+// Renders the JSON array of items in src, as taken by renderBatch in katex.js,
+// and returns the JSON array of results, with its length in len. The result
+// must be freed with free_result. On failure, returns null, with a negated Error
+// in len. interrupt is as for render.
+const char *render_batch(State *state, void *src, size_t src_len, size_t *len, const int *interrupt);
+
+// Frees a result returned by render_batch.
+void free_result(State *state, const char *result);
+
+// Returns the bytecode of module 0 (KaTeX) or 1 (the API used by render).
+const uint8_t *bytecode(int module, size_t *len);
@@ -7,12 +7,138 @@ function warn(msg) {
 }
 function noop() {}
 
-function render(tex, displayMode, warnings) {
+// KaTeX stores macros defined by TeX as lists of tokens, in reverse order. Turn
+// them back into strings so they can be passed back in through the macros
+// option. Delimited parameters are lost.
+//
+// KaTeX infers the number of arguments of a macro given as a string from the
+// parameters #1, #2, ... that it uses, so a macro that ignores some of its
+// arguments starts with \@firstoftwo{}{#1...}, which uses them but expands to
+// nothing.
+function untokenize(macro) {
+    let tex = "";
+    for (let i = macro.tokens.length - 1; i >= 0; i--) {
+        const text = macro.tokens[i].text;
+        tex += text;
+        if (/^\\[a-zA-Z@]+$/.test(text)) {
+            tex += " ";
+        }
+    }
+    const numArgs = macro.numArgs || 0;
+    if (inferArgs(tex) < numArgs) {
+        let params = "";
+        for (let i = 1; i <= numArgs; i++) {
+            params += "#" + i;
+        }
+        tex = "\\@firstoftwo{}{" + params + "}" + tex;
+    }
+    return tex;
+}
+
+// Returns the number of arguments KaTeX infers for a macro defined as tex, as
+// MacroExpander._getExpansion does.
+function inferArgs(tex) {
+    const stripped = tex.replace(/##/g, "");
+    let numArgs = 0;
+    while (stripped.indexOf("#" + (numArgs + 1)) !== -1) {
+        numArgs++;
+    }
+    return numArgs;
+}
+
+// Returns the macros that were defined, redefined (as strings) or undefined (as
+// null) relative to before, or undefined if there are none. Built-in function
+// macros, e.g. from \let\a\frac, are not representable and are skipped.
+function definitions(macros, before) {
+    let defs = undefined;
+    for (const name in macros) {
+        const macro = macros[name];
+        if (macro === before[name]) {
+            continue;
+        }
+        if (typeof macro === "string") {
+            defs = defs || {};
+            defs[name] = macro;
+        } else if (macro && macro.tokens) {
+            defs = defs || {};
+            defs[name] = untokenize(macro);
+        }
+    }
+    for (const name in before) {
+        if (!(name in macros)) {
+            defs = defs || {};
+            defs[name] = null;
+        }
+    }
+    return defs;
+}
+
+// options is undefined, or a JSON object of KaTeX options. It is parsed for
+// every call, as KaTeX mutates the macros object it is given. If
+// options.reportErrors is set, TeX that fails to parse is reported as if
+// options.throwOnError were set, but also rendered as if it were not.
+//
+// Returns the HTML, or, if options.globalGroup is set and macros were defined,
+// or the TeX failed to parse and options.throwOnError or options.reportErrors
+// is set, a JSON object with the HTML, the definitions and the error. As the
+// HTML always starts with a tag, these are distinguished by the first
+// character.
+function render(tex, displayMode, warnings, options) {
     console.warn = warnings ? warn : noop;
-    return katex.renderToString(tex, {
-        throwOnError: false,
-        displayMode: displayMode,
-    });
+    let settings = options ? JSON.parse(options) : {};
+    const report = !!settings.reportErrors && !settings.throwOnError;
+    delete settings.reportErrors;
+    settings.throwOnError = !!settings.throwOnError || report;
+    settings.displayMode = displayMode;
+    if (!settings.globalGroup && !settings.throwOnError) {
+        return katex.renderToString(tex, settings);
+    }
+    settings.macros = settings.macros || {};
+    const before = Object.assign({}, settings.macros);
+    let result = {};
+    try {
+        result.html = katex.renderToString(tex, settings);
+    } catch (e) {
+        if (!(e instanceof katex.ParseError)) {
+            throw e;
+        }
+        result.error = { message: e.rawMessage, position: e.position, length: e.length };
+        if (report) {
+            // Render the error message from the macros as they were before, so
+            // definitions made before the error are only made once.
+            settings.throwOnError = false;
+            settings.macros = Object.assign({}, before);
+            result.html = katex.renderToString(tex, settings);
+        }
+    }
+    if (settings.globalGroup) {
+        result.macros = definitions(settings.macros, before);
+    }
+    if (result.error === undefined && result.macros === undefined) {
+        return result.html;
+    }
+    return JSON.stringify(result);
+}
+
+// items is a JSON array of {tex, displayMode, warnings, options} objects, which
+// are rendered as by render.
+//
+// Returns a JSON array of the results of render, in the same order. Items that
+// throw an error other than a parse error are null, except for internal errors,
+// e.g., running out of memory, which may leave the runtime unusable, and so are
+// thrown.
+function renderBatch(items) {
+    return JSON.stringify(JSON.parse(items).map(function (item) {
+        try {
+            return render(item.tex, item.displayMode, item.warnings, item.options);
+        } catch (e) {
+            if (e instanceof InternalError) {
+                throw e;
+            }
+            return null;
+        }
+    }));
 }
 
 globalThis.render = render;
+globalThis.renderBatch = renderBatch;
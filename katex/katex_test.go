@@ -2,7 +2,11 @@ package katex_test
 
 import (
 	"bytes"
+	"context"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/graemephi/goldmark-qjs-katex/katex"
 )
@@ -66,3 +70,341 @@ func TestTooLarge(t *testing.T) {
 		t.Errorf("accepted too large input")
 	}
 }
+
+func TestMacros(t *testing.T) {
+	macros := map[string]string{
+		"\\R":    "\\mathbb{R}",
+		"\\norm": "\\left\\lVert#1\\right\\rVert",
+	}
+	in := []byte("\\norm{x} \\in \\R")
+	got := []byte{}
+	err := katex.RenderWithMacros(&got, in, katex.Inline, macros)
+	if err != nil {
+		t.Errorf("Failed to convert %s: %s", in, err)
+		return
+	}
+	if bytes.Contains(got, []byte("katex-error")) || !bytes.Contains(got, []byte("mathbb")) || !bytes.Contains(got, []byte("∥")) {
+		t.Errorf("macros were not expanded: %s", got)
+	}
+
+	// Definitions do not persist between calls.
+	err = katex.RenderWithMacros(&got, []byte("\\gdef\\a{b}"), katex.Inline, macros)
+	if err != nil {
+		t.Errorf("Failed to convert: %s", err)
+		return
+	}
+	err = katex.RenderWithMacros(&got, []byte("\\a"), katex.Inline, macros)
+	if err != nil {
+		t.Errorf("Failed to convert: %s", err)
+		return
+	}
+	if !bytes.Contains(got, []byte("#cc0000")) {
+		t.Errorf("\\gdef persisted: %s", got)
+	}
+}
+
+func TestRenderGlobal(t *testing.T) {
+	macros := map[string]string{}
+	dest := []byte{}
+	for _, in := range []string{"\\gdef\\vec#1{\\mathbf{#1}}", "\\newcommand\\half{\\frac12}"} {
+		if err := katex.RenderGlobal(&dest, []byte(in), katex.Inline, macros); err != nil {
+			t.Fatalf("Failed to convert %s: %s", in, err)
+		}
+		if !bytes.HasPrefix(dest, []byte("<span")) {
+			t.Errorf("Bad HTML for %s: %s", in, dest)
+		}
+	}
+	if macros["\\vec"] != "\\mathbf {#1}" || macros["\\half"] != "\\frac 12" {
+		t.Errorf("unexpected macros: %v", macros)
+	}
+
+	in := []byte("\\vec{x} = \\half")
+	if err := katex.RenderGlobal(&dest, in, katex.Inline, macros); err != nil {
+		t.Fatalf("Failed to convert %s: %s", in, err)
+	}
+	if !bytes.Contains(dest, []byte("mathbf")) || !bytes.Contains(dest, []byte("mfrac")) || bytes.Contains(dest, []byte("#cc0000")) {
+		t.Errorf("macros were not expanded: %s", dest)
+	}
+
+	// Without macros, definitions only apply to the TeX itself.
+	in = []byte("\\gdef\\y{y}\\y")
+	if err := katex.RenderGlobal(&dest, in, katex.Inline, nil); err != nil || bytes.Contains(dest, []byte("#cc0000")) {
+		t.Errorf("Failed to convert %s without macros: %v, %s", in, err, dest)
+	}
+	results := katex.RenderBatch([]katex.Item{{TeX: in, Options: &katex.Options{GlobalGroup: true}}})
+	if results[0].Err != nil || bytes.Contains(results[0].HTML, []byte("#cc0000")) {
+		t.Errorf("Failed to render %s in a batch without macros: %v, %s", in, results[0].Err, results[0].HTML)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		in       string
+		opts     katex.Options
+		has, not string
+	}{
+		{"x", katex.Options{}, "katex-html", "katex-display"},
+		{"x", katex.Options{DisplayMode: true}, "katex-display", "fleqn"},
+		{"x", katex.Options{DisplayMode: true, Fleqn: true}, "fleqn", ""},
+		{"x", katex.Options{DisplayMode: true, Leqno: true}, "leqno", ""},
+		{"x", katex.Options{Output: katex.MathML}, "<math", "katex-html"},
+		{"x", katex.Options{Output: katex.HTML}, "katex-html", "<math"},
+		{"\\frac", katex.Options{ErrorColor: "#00cc00"}, "#00cc00", "#cc0000"},
+		{"\\href{https://a.b}{x}", katex.Options{}, "#cc0000", "href=\"https://a.b\""},
+		{"\\href{https://a.b}{x}", katex.Options{Trust: true}, "href=\"https://a.b\"", "#cc0000"},
+		{"\\color{red}x", katex.Options{ColorIsTextColor: true}, "red", ""},
+		{"\\rule{10em}{1em}", katex.Options{MaxSize: 1}, "width:1em", "width:10em"},
+		{"\\def\\a{\\a}\\a", katex.Options{MaxExpand: 10}, "katex-error", ""},
+		{"é", katex.Options{Strict: "error"}, "katex-error", ""},
+		{"\\R", katex.Options{Macros: map[string]string{"\\R": "\\mathbb{R}"}}, "mathbb", "#cc0000"},
+	}
+	dest := []byte{}
+	for _, test := range tests {
+		if err := katex.RenderWithOptions(&dest, []byte(test.in), &test.opts); err != nil {
+			t.Errorf("Failed to convert %s: %s", test.in, err)
+			continue
+		}
+		if !bytes.Contains(dest, []byte(test.has)) || (test.not != "" && bytes.Contains(dest, []byte(test.not))) {
+			t.Errorf("%s with %+v: want %q and not %q, got:\n%s", test.in, test.opts, test.has, test.not, dest)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	dest := []byte{}
+	if err := katex.Render(&dest, []byte("éé}"), katex.Inline); err != nil {
+		t.Errorf("Render without Throw returned an error: %s", err)
+	}
+
+	err := katex.Render(&dest, []byte("éé}"), katex.Inline|katex.Throw)
+	pe, ok := err.(*katex.ParseError)
+	if !ok {
+		t.Fatalf("expected a *ParseError, got %v", err)
+	}
+	if pe.Position != 4 || pe.Length != 1 || len(dest) != 0 {
+		t.Errorf("unexpected error %+v, dest %q", pe, dest)
+	}
+
+	err = katex.RenderWithOptions(&dest, []byte("x^"), &katex.Options{ThrowOnError: true})
+	pe, ok = err.(*katex.ParseError)
+	if !ok {
+		t.Fatalf("expected a *ParseError, got %v", err)
+	}
+	if pe.Position != 1 || pe.Message != "Expected group after '^'" {
+		t.Errorf("unexpected error %+v", pe)
+	}
+	// With one call, definitions made before the error are only made once.
+	macros := map[string]string{`\n`: "1"}
+	pe, err = katex.RenderReporting(context.Background(), &dest, []byte(`\xdef\n{\n+1}}`), &katex.Options{Macros: macros, GlobalGroup: true})
+	if err != nil || pe == nil || !bytes.Contains(dest, []byte("katex-error")) {
+		t.Errorf("expected a rendered parse error, got %v, %v, %q", pe, err, dest)
+	}
+	if macros[`\n`] != "1+1" {
+		t.Errorf(`expected \n to be 1+1, got %q`, macros[`\n`])
+	}
+}
+
+func TestRenderContext(t *testing.T) {
+	// Expands forever, without hitting the default maxExpand limit.
+	src := []byte(`\def\a{\a\a}\a`)
+	opts := &katex.Options{MaxExpand: 1 << 30}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	dest := []byte{}
+	start := time.Now()
+	if err := katex.RenderContext(ctx, &dest, src, opts); err != katex.ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to time out", elapsed)
+	}
+
+	// The runtime is usable after an interrupt.
+	if err := katex.RenderContext(context.Background(), &dest, []byte("x"), &katex.Options{}); err != nil || len(dest) == 0 {
+		t.Errorf("failed to render after timing out: %v", err)
+	}
+
+	if err := katex.RenderContext(ctx, &dest, []byte("x"), &katex.Options{}); err != katex.ErrTimeout {
+		t.Errorf("expected ErrTimeout with a done context, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	defer katex.SetLimits(katex.Limits{})
+	dest := []byte{}
+
+	katex.SetLimits(katex.Limits{Stack: 16 << 10})
+	nested := strings.Repeat("{", 100) + "x" + strings.Repeat("}", 100)
+	if err := katex.Render(&dest, []byte(nested), katex.Inline); err != katex.ErrStackOverflow {
+		t.Errorf("expected ErrStackOverflow, got %v", err)
+	}
+
+	katex.SetLimits(katex.Limits{Memory: 32 << 20})
+	large := strings.Repeat(`\frac{x}{y}`, 1<<16)
+	if err := katex.Render(&dest, []byte(large), katex.Inline); err != katex.ErrOutOfMemory {
+		t.Errorf("expected ErrOutOfMemory, got %v", err)
+	}
+	if err := katex.Render(&dest, []byte("x"), katex.Inline); err != nil {
+		t.Errorf("failed to render within limits: %v", err)
+	}
+
+	katex.SetLimits(katex.Limits{})
+	if err := katex.Render(&dest, []byte(nested), katex.Inline); err != nil {
+		t.Errorf("failed to render after removing limits: %v", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := katex.NewPool(2)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dest := []byte{}
+			for j := 0; j < 4; j++ {
+				if err := p.RenderWithOptions(&dest, []byte("x^2"), &katex.Options{}); err != nil || len(dest) == 0 {
+					t.Errorf("failed to render: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := p.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+	dest := []byte{}
+	if err := p.RenderWithOptions(&dest, []byte("x"), &katex.Options{}); err != katex.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// The package-level functions keep working after Close.
+	if err := katex.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+	if err := katex.Render(&dest, []byte("x"), katex.Inline); err != nil || len(dest) == 0 {
+		t.Errorf("failed to render after Close: %v", err)
+	}
+}
+
+func TestPoolWait(t *testing.T) {
+	p := katex.NewPool(1)
+	defer p.Close()
+
+	// Hold the only runtime with TeX that renders until interrupted.
+	busy, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		dest := []byte{}
+		errc <- p.RenderContext(busy, &dest, []byte(`\def\a{\a\a}\a`), &katex.Options{MaxExpand: 1 << 30})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancelWait := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelWait()
+	dest := []byte{}
+	if err := p.RenderContext(ctx, &dest, []byte("x"), &katex.Options{}); err != katex.ErrTimeout {
+		t.Errorf("expected ErrTimeout waiting for a runtime, got %v", err)
+	}
+	cancel()
+	if err := <-errc; err != katex.ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if err := p.RenderWithOptions(&dest, []byte("x"), &katex.Options{}); err != nil {
+		t.Errorf("failed to render after waiting: %v", err)
+	}
+}
+
+func TestRecycle(t *testing.T) {
+	defer katex.SetLimits(katex.Limits{})
+	p := katex.NewPool(1)
+	defer p.Close()
+	opts := &katex.Options{}
+	dest := []byte{}
+
+	// Failures replace the runtime.
+	katex.SetLimits(katex.Limits{Memory: 8 << 20})
+	large := []byte(strings.Repeat(`\frac{x}{y}`, 1<<12))
+	if err := p.RenderWithOptions(&dest, large, opts); err != katex.ErrOutOfMemory {
+		t.Errorf("expected ErrOutOfMemory, got %v", err)
+	}
+	katex.SetLimits(katex.Limits{})
+	if err := p.RenderWithOptions(&dest, []byte("x"), opts); err != nil || len(dest) == 0 {
+		t.Errorf("failed to render after running out of memory: %v", err)
+	}
+	if n := katex.RuntimesCreated(p); n != 2 {
+		t.Errorf("expected 2 runtimes after a failure, got %d", n)
+	}
+
+	// Parse errors do not.
+	if err := p.RenderWithOptions(&dest, []byte("x^"), opts); err != nil {
+		t.Errorf("failed to render a parse error: %v", err)
+	}
+	if n := katex.RuntimesCreated(p); n != 2 {
+		t.Errorf("expected 2 runtimes after a parse error, got %d", n)
+	}
+
+	p.SetMaxRenders(1)
+	for i := 0; i < 3; i++ {
+		if err := p.RenderWithOptions(&dest, []byte("x"), opts); err != nil || len(dest) == 0 {
+			t.Errorf("failed to render with new runtimes: %v", err)
+		}
+	}
+	// The idle runtime renders once more before it is replaced.
+	if n := katex.RuntimesCreated(p); n != 4 {
+		t.Errorf("expected 4 runtimes after 3 renders with SetMaxRenders(1), got %d", n)
+	}
+}
+
+func TestRenderBatch(t *testing.T) {
+	macros := map[string]string{}
+	items := []katex.Item{
+		{TeX: []byte("x^2")},
+		{TeX: []byte(`\sum_i`), Options: &katex.Options{DisplayMode: true}},
+		{TeX: nil},
+		{TeX: []byte("éé}"), Options: &katex.Options{ThrowOnError: true}},
+		{TeX: []byte(`\gdef\R{\mathbb{R}}`), Options: &katex.Options{Macros: macros, GlobalGroup: true}},
+		{TeX: []byte("x^2")},
+	}
+	results := katex.RenderBatch(items)
+	if len(results) != len(items) {
+		t.Fatalf("expected %d results, got %d", len(items), len(results))
+	}
+	for i, item := range items {
+		if i == 3 {
+			continue
+		}
+		opts := item.Options
+		if opts == nil {
+			opts = &katex.Options{}
+		}
+		opts.Macros = nil
+		opts.GlobalGroup = false
+		dest := []byte{}
+		if err := katex.RenderWithOptions(&dest, item.TeX, opts); err != nil {
+			t.Fatal(err)
+		}
+		if results[i].Err != nil || !bytes.Equal(results[i].HTML, dest) {
+			t.Errorf("item %d: got %q, %v, expected %q", i, results[i].HTML, results[i].Err, dest)
+		}
+	}
+	if pe, ok := results[3].Err.(*katex.ParseError); !ok || pe.Position != 4 || len(results[3].HTML) != 0 {
+		t.Errorf("expected a *ParseError at 4, got %q, %v", results[3].HTML, results[3].Err)
+	}
+	if macros[`\R`] != `\mathbb {R}` {
+		t.Errorf("definitions not added to macros: %v", macros)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	items = []katex.Item{
+		{TeX: []byte("x")},
+		{TeX: []byte(`\def\a{\a\a}\a`), Options: &katex.Options{MaxExpand: 1 << 30}},
+	}
+	for _, result := range katex.RenderBatchContext(ctx, items) {
+		if result.Err != katex.ErrTimeout {
+			t.Errorf("expected ErrTimeout, got %v", result.Err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package katex
+
+/*
+#include "katex.h"
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed indicates that a Pool was used after being closed.
+var ErrClosed = errors.New("KaTeX pool closed")
+
+// Pool is a bounded pool of QuickJS runtimes with KaTeX loaded. Each call to
+// render checks out a runtime for its duration, so a Pool of size n renders at
+// most n TeX strings at once, waiting for a runtime otherwise, and holds at most
+// n runtimes. Runtimes are created as needed, and each uses a few megabytes.
+//
+// A runtime is discarded and replaced after a render fails with an error other
+// than a *ParseError, e.g., ErrInconsistent, ErrOutOfMemory or ErrTimeout, as
+// its state may be corrupt, and optionally after a number of renders, see
+// SetMaxRenders.
+//
+// The package-level functions use a default Pool, sized by SetPoolSize.
+type Pool struct {
+	// maxRenders and created are accessed atomically, so must be 64-bit
+	// aligned. created counts the runtimes created, for tests.
+	maxRenders int64
+	created    int64
+
+	// idle holds runtimes that are not checked out.
+	idle chan *qjsRuntime
+	// tokens holds permission to create a runtime, one for each runtime that
+	// may be created without exceeding the size of the pool.
+	tokens chan struct{}
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPool returns a Pool of at most size runtimes. Sizes less than one are
+// treated as one.
+func NewPool(size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{
+		idle:   make(chan *qjsRuntime, size),
+		tokens: make(chan struct{}, size),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		p.tokens <- struct{}{}
+	}
+	return p
+}
+
+// qjsRuntime is a runtime in a Pool.
+type qjsRuntime struct {
+	state   *C.State
+	renders int64
+}
+
+// SetMaxRenders makes runtimes be replaced after n renders, bounding the effects
+// of leaks and fragmentation in long-running processes. Zero, the default, is
+// no limit.
+func (p *Pool) SetMaxRenders(n int) {
+	atomic.StoreInt64(&p.maxRenders, int64(n))
+}
+
+// get checks out a runtime, preferring idle runtimes to creating new ones.
+func (p *Pool) get(ctx context.Context) (*qjsRuntime, error) {
+	select {
+	case <-p.done:
+		return nil, ErrClosed
+	case rt := <-p.idle:
+		return rt, nil
+	default:
+	}
+	select {
+	case <-p.done:
+		return nil, ErrClosed
+	case rt := <-p.idle:
+		return rt, nil
+	case <-p.tokens:
+		state := C.new_state()
+		if state == nil {
+			p.tokens <- struct{}{}
+			return nil, ErrOutOfMemory
+		}
+		atomic.AddInt64(&p.created, 1)
+		return &qjsRuntime{state: state}, nil
+	case <-ctx.Done():
+		return nil, ErrTimeout
+	}
+}
+
+// put returns a runtime checked out by get, after a render that returned err.
+// The runtime is freed if the render failed, or it has reached its maximum
+// number of renders, and a new runtime is created when one is next needed.
+func (p *Pool) put(rt *qjsRuntime, err error) {
+	rt.renders++
+	max := atomic.LoadInt64(&p.maxRenders)
+	recycle := err != nil || (max > 0 && rt.renders >= max)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.closed:
+		C.free_state(rt.state)
+	case recycle:
+		C.free_state(rt.state)
+		p.tokens <- struct{}{}
+	default:
+		p.idle <- rt
+	}
+}
+
+// Close frees the runtimes in the pool. Runtimes that are checked out are freed
+// when their render returns. Rendering with the pool after Close returns
+// ErrClosed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for {
+		select {
+		case rt := <-p.idle:
+			C.free_state(rt.state)
+		default:
+			return nil
+		}
+	}
+}
+
+var defaultPoolMu sync.RWMutex
+var defaultPoolSize = runtime.GOMAXPROCS(0)
+var defaultPoolMaxRenders = 0
+var defaultPoolValue = NewPool(defaultPoolSize)
+
+func defaultPool() *Pool {
+	defaultPoolMu.RLock()
+	defer defaultPoolMu.RUnlock()
+	return defaultPoolValue
+}
+
+// SetPoolSize sets the size of the Pool used by the package-level functions,
+// which is GOMAXPROCS by default. The runtimes of the previous Pool are freed.
+func SetPoolSize(size int) {
+	defaultPoolMu.Lock()
+	old := defaultPoolValue
+	defaultPoolSize = size
+	defaultPoolValue = newDefaultPool()
+	defaultPoolMu.Unlock()
+	old.Close()
+}
+
+// SetMaxRenders sets the maximum renders of the runtimes used by the
+// package-level functions, as by Pool.SetMaxRenders.
+func SetMaxRenders(n int) {
+	defaultPoolMu.Lock()
+	defer defaultPoolMu.Unlock()
+	defaultPoolMaxRenders = n
+	defaultPoolValue.SetMaxRenders(n)
+}
+
+// newDefaultPool returns a new default pool. defaultPoolMu must be held.
+func newDefaultPool() *Pool {
+	p := NewPool(defaultPoolSize)
+	p.SetMaxRenders(defaultPoolMaxRenders)
+	return p
+}
+
+// Close frees the runtimes used by the package-level functions. Unlike
+// Pool.Close, later calls to package-level functions are not an error, and
+// create new runtimes as needed.
+func Close() error {
+	defaultPoolMu.Lock()
+	old := defaultPoolValue
+	defaultPoolValue = newDefaultPool()
+	defaultPoolMu.Unlock()
+	return old.Close()
+}
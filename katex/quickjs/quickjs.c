@@ -2022,6 +2022,17 @@ void JS_SetMaxStackSize(JSContext *ctx, size_t stack_size)
     ctx->stack_size = stack_size;
 }
 
+/* goldmark-qjs-katex local patch, backported from later releases: runtimes
+   are moved between threads by katex.Pool, and without this the stack
+   overflow check measures against another thread's stack, failing spuriously
+   or missing real overflows. */
+/* should be called when changing thread to update the stack top value
+   used to check stack overflow. */
+void JS_UpdateStackTop(JSContext *ctx)
+{
+    ctx->stack_top = js_get_stack_pointer();
+}
+
 static inline BOOL is_strict_mode(JSContext *ctx)
 {
     JSStackFrame *sf = ctx->current_stack_frame;
@@ -6115,7 +6126,13 @@ static void build_backtrace(JSContext *ctx, JSValueConst error_obj,
     }
  done:
     dbuf_putc(&dbuf, '\0');
-    str = JS_NewString(ctx, (char *)dbuf.buf);
+    /* goldmark-qjs-katex local patch: the buffer is NULL if it could not be
+       allocated, e.g., under a memory limit. Fixed upstream in later
+       releases; keep until QuickJS is updated past it. */
+    if (dbuf_error(&dbuf))
+        str = JS_NULL;
+    else
+        str = JS_NewString(ctx, (char *)dbuf.buf);
     dbuf_free(&dbuf);
     JS_DefinePropertyValue(ctx, error_obj, JS_ATOM_stack, str,
                            JS_PROP_WRITABLE | JS_PROP_CONFIGURABLE);
@@ -6156,9 +6173,13 @@ static JSValue JS_ThrowError2(JSContext *ctx, JSErrorEnum error_num,
         JS_DefinePropertyValue(ctx, obj, JS_ATOM_message,
                                JS_NewString(ctx, buf),
                                JS_PROP_WRITABLE | JS_PROP_CONFIGURABLE);
-    }
-    if (add_backtrace) {
-        build_backtrace(ctx, obj, NULL, 0, 0);
+        /* goldmark-qjs-katex local patch: only build the backtrace on a
+           real error object. Building it on JS_NULL throws again, and under
+           a memory limit recurses until the stack overflows. Fixed upstream
+           in later releases; keep until QuickJS is updated past it. */
+        if (add_backtrace) {
+            build_backtrace(ctx, obj, NULL, 0, 0);
+        }
     }
     ret = JS_Throw(ctx, obj);
     return ret;
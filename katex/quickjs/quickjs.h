@@ -321,6 +321,8 @@ void *JS_GetContextOpaque(JSContext *ctx);
 void JS_SetContextOpaque(JSContext *ctx, void *opaque);
 JSRuntime *JS_GetRuntime(JSContext *ctx);
 void JS_SetMaxStackSize(JSContext *ctx, size_t stack_size);
+/* goldmark-qjs-katex local patch, see quickjs.c */
+void JS_UpdateStackTop(JSContext *ctx);
 void JS_SetClassProto(JSContext *ctx, JSClassID class_id, JSValue obj);
 JSValue JS_GetClassProto(JSContext *ctx, JSClassID class_id);
 
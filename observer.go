@@ -0,0 +1,75 @@
+package qjskatex
+
+import (
+	"time"
+
+	"github.com/graemephi/goldmark-qjs-katex/katex"
+)
+
+// Observer observes the TeX rendered by an Extension, e.g., to export metrics
+// or log slow formulas. Implementations must be safe for concurrent use.
+type Observer interface {
+	// ObserveRender is called once for each formula, after it is rendered or
+	// loaded from the cache.
+	ObserveRender(e RenderEvent)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(e RenderEvent)
+
+// ObserveRender calls f(e).
+func (f ObserverFunc) ObserveRender(e RenderEvent) {
+	f(e)
+}
+
+// RenderEvent describes a formula rendered or loaded from the cache.
+type RenderEvent struct {
+	// TeX is the TeX source. It must not be retained after ObserveRender
+	// returns.
+	TeX []byte
+	// Mode is the mode the TeX was rendered in.
+	Mode katex.Mode
+	// Duration is the time spent looking up and rendering the TeX. TeX
+	// rendered in a batch with other TeX, see Extension.RenderWorkers, is
+	// reported as taking an equal share of the batch's time.
+	Duration time.Duration
+	// CacheHit is set if the TeX was not rendered, as it was cached, or it
+	// appeared earlier in the same document, see Extension.RenderWorkers.
+	CacheHit bool
+	// OutputSize is the length of the rendered HTML.
+	OutputSize int
+	// Err is the error rendering returned, if any.
+	Err error
+	// ParseError is the parse error KaTeX reported, whether or not it was
+	// rendered.
+	ParseError *katex.ParseError
+}
+
+// observe records e in the statistics of the context of n, which holds the TeX,
+// and reports it to the observer, if any.
+func (r *renderer) observe(n *Node, e RenderEvent) {
+	c := n.context
+	c.mu.Lock()
+	if e.CacheHit {
+		c.hits++
+	}
+	c.renderTime += e.Duration
+	c.mu.Unlock()
+	if r.observer != nil {
+		r.observer.ObserveRender(e)
+	}
+}
+
+// observeHit reports the TeX of n loaded from the cache as entry, taking d to
+// look up.
+func (r *renderer) observeHit(n *Node, tex []byte, d time.Duration, entry *CacheEntry) {
+	r.observe(n, RenderEvent{
+		TeX:        tex,
+		Mode:       n.mode,
+		Duration:   d,
+		CacheHit:   true,
+		OutputSize: len(entry.HTML),
+		Err:        entry.Err,
+		ParseError: entry.ParseError,
+	})
+}
@@ -0,0 +1,152 @@
+package qjskatex
+
+import (
+	stdcontext "context"
+	"errors"
+	"runtime"
+	"sort"
+
+	"github.com/graemephi/goldmark-qjs-katex/katex"
+
+	gma "github.com/yuin/goldmark/ast"
+	gmp "github.com/yuin/goldmark/parser"
+	gmt "github.com/yuin/goldmark/text"
+)
+
+// ErrNotExtended indicates that an Extension was used before being passed to
+// goldmark.New.
+var ErrNotExtended = errors.New("qjskatex: Extension has not extended a goldmark.Markdown")
+
+// prerenderKey is set in the parser Context of documents parsed by Prerender, so
+// that the transformer that renders documents once they are parsed, see
+// Extension.RenderWorkers, does not render them as well.
+var prerenderKey = gmp.NewContextKey()
+
+type prerenderJob struct {
+	doc   int
+	nodes []*Node
+}
+
+type prerenderResult struct {
+	doc int
+	n   *Node
+	pe  *katex.ParseError
+	err error
+}
+
+// Prerender fills the cache with the TeX in sources, so that converting them
+// later does not render any TeX. Sources are parsed with the goldmark.Markdown
+// that e extends, and their TeX is rendered concurrently, across as many
+// QuickJS runtimes as GOMAXPROCS. With DocumentMacros, the TeX of each source is
+// rendered in order, and only sources are rendered concurrently.
+//
+// If progress is not nil, it is called after each formula is rendered with the
+// number of formulas rendered and the total, from one goroutine at a time.
+//
+// Prerender returns the TeX that failed to parse in each source, as
+// ReportKatexErrors would when converting it. If ctx is done, it stops as soon
+// as possible, interrupting the TeX being rendered, and returns ctx.Err().
+// Otherwise it returns the first error other than a parse error that rendering
+// returned, if any.
+func (e *Extension) Prerender(ctx stdcontext.Context, sources [][]byte, progress func(done, total int)) ([][]Diagnostic, error) {
+	if e.md == nil {
+		return nil, ErrNotExtended
+	}
+	r := &e.r
+
+	var jobs []prerenderJob
+	total := 0
+	for i, source := range sources {
+		pc := gmp.NewContext()
+		pc.Set(prerenderKey, true)
+		doc := e.md.Parser().Parse(gmt.NewReader(source), gmp.WithContext(pc))
+		var nodes []*Node
+		gma.Walk(doc, func(n gma.Node, entering bool) (gma.WalkStatus, error) {
+			if n, ok := n.(*Node); ok && entering {
+				nodes = append(nodes, n)
+			}
+			return gma.WalkContinue, nil
+		})
+		total += len(nodes)
+		if r.documentMacros {
+			jobs = append(jobs, prerenderJob{doc: i, nodes: nodes})
+		} else {
+			for _, n := range nodes {
+				jobs = append(jobs, prerenderJob{doc: i, nodes: []*Node{n}})
+			}
+		}
+	}
+
+	ctx, cancel := stdcontext.WithCancel(ctx)
+	defer cancel()
+	jobc := make(chan prerenderJob)
+	results := make(chan prerenderResult)
+	go func() {
+		defer close(jobc)
+		for _, job := range jobs {
+			select {
+			case jobc <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	workers := runtime.GOMAXPROCS(0)
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			// Nodes are rendered with a context owned by the worker, rather than
+			// their document's, which is shared with other workers.
+			c := &context{buf: make([]byte, 4096)}
+			for job := range jobc {
+				c.macros = nil
+				for _, n := range job.nodes {
+					if ctx.Err() != nil {
+						break
+					}
+					n.context = c
+					tex := n.tex(sources[job.doc])
+					result := prerenderResult{doc: job.doc, n: n}
+					if r.documentMacros {
+						_, result.pe, result.err = r.renderGlobal(ctx, tex, n)
+					} else {
+						_, result.pe, result.err = r.renderTex(ctx, tex, n)
+					}
+					results <- result
+				}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < workers; i++ {
+			<-done
+		}
+		close(results)
+	}()
+
+	diagnostics := make([][]Diagnostic, len(sources))
+	var err error
+	count := 0
+	for result := range results {
+		count++
+		if progress != nil {
+			progress(count, total)
+		}
+		if pe := result.pe; pe != nil {
+			offset := result.n.sourceOffset(pe.Position)
+			diagnostics[result.doc] = append(diagnostics[result.doc], newDiagnostic(sources[result.doc], result.n, offset, pe))
+		}
+		if result.err != nil && result.err != error(result.pe) && err == nil {
+			err = result.err
+		}
+	}
+	for _, d := range diagnostics {
+		sort.Slice(d, func(i, j int) bool { return d[i].Offset < d[j].Offset })
+	}
+	if ctx.Err() != nil {
+		return diagnostics, ctx.Err()
+	}
+	return diagnostics, err
+}
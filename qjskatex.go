@@ -1,6 +1,7 @@
 // Package qjskatex is an extension for goldmark (github.com/yuin/goldmark) to perform server-side KaTeX rendering.
 //
-// Note: the extension holds a sync.Map to cache rendered TeX for performance; it grows without bound.
+// Note: the extension caches rendered TeX for performance. The cache is bounded; see
+// Extension.CacheMaxEntries and Extension.CacheMaxBytes, or provide your own with Extension.Cache.
 //
 // 	markdown := goldmark.New(
 // 		goldmark.WithExtensions(&qjskatex.Extension{}),
@@ -8,7 +9,13 @@
 package qjskatex
 
 import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/graemephi/goldmark-qjs-katex/katex"
@@ -28,7 +35,72 @@ type Node struct {
 	mode katex.Mode
 	pos  gmt.Segment
 
+	// lines holds the TeX of display blocks whose lines are not contiguous in the
+	// source, e.g., inside block quotes. Otherwise it is nil and the TeX is pos.
+	lines []gmt.Segment
+
+	// open and close are the delimiters in the source, see Delimiters.
+	open, close []byte
+
 	context *context
+
+	// batched is set once the document holding the node has been rendered by
+	// renderDocument, and rendered is set if result holds the node's result,
+	// as it was cached or rendered there.
+	batched  bool
+	rendered bool
+	result   CacheEntry
+}
+
+// ParseError is a TeX parse error in a markdown document. Rendering returns
+// these when Extension.ThrowOnError is set.
+type ParseError struct {
+	// Offset is the byte offset of the error in the markdown source.
+	Offset int
+	// Err is the error reported by KaTeX. Its position is relative to the TeX.
+	Err *katex.ParseError
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("KaTeX parse error at byte %d: %s", e.Offset, e.Err.Message)
+}
+
+// Unwrap returns the error reported by KaTeX.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorRenderer writes HTML to w in place of the TeX tex of n, which KaTeX
+// failed to parse with err. n.Delimiters returns the delimiters around tex, e.g.,
+// to fall back to the literal text.
+type ErrorRenderer func(w gmu.BufWriter, n *Node, tex []byte, err *katex.ParseError)
+
+// Diagnostic describes TeX in a markdown document that KaTeX failed to parse.
+type Diagnostic struct {
+	// Segment is the TeX in the markdown source. It includes any block quote
+	// markers, etc., between lines of display blocks.
+	Segment gmt.Segment
+	// Offset is the byte offset of the error in the markdown source.
+	Offset int
+	// Line and Column are the 1-based line and byte column of the error in the
+	// markdown source.
+	Line, Column int
+	// Mode is the mode the TeX was rendered in.
+	Mode katex.Mode
+	// Err is the error reported by KaTeX. Its position is relative to the TeX.
+	Err *katex.ParseError
+}
+
+func newDiagnostic(source []byte, n *Node, offset int, err *katex.ParseError) Diagnostic {
+	before := source[:offset]
+	return Diagnostic{
+		Segment: n.pos,
+		Offset:  offset,
+		Line:    bytes.Count(before, []byte{'\n'}) + 1,
+		Column:  offset - bytes.LastIndexByte(before, '\n'),
+		Mode:    n.mode,
+		Err:     err,
+	}
 }
 
 // KindTex indicates that a node is of kind qjskatex.Node.
@@ -39,15 +111,118 @@ func (n *Node) Kind() gma.NodeKind {
 	return KindTex
 }
 
+// Mode returns the mode the TeX is rendered in, either katex.Inline or
+// katex.Display.
+func (n *Node) Mode() katex.Mode {
+	return n.mode
+}
+
+// Delimiters returns the delimiters around the TeX in the markdown source, e.g.,
+// "$" and "$", or `\(` and `\)`, so that together with the TeX they reproduce
+// the source, e.g., for an ErrorRenderer to fall back to the literal text. For
+// display blocks, they are the lines that open and close the block, e.g., "$$"
+// and "$$", or "```math" and "```", without line endings or the markers of
+// containers, and the TeX is on the lines between them. close is empty if a
+// fenced code block is closed by the end of its container.
+func (n *Node) Delimiters() (open, close []byte) {
+	return n.open, n.close
+}
+
+// IsRaw returns true, as TeX is not markdown.
+func (n *Node) IsRaw() bool {
+	return true
+}
+
 // Dump dumps a textual representation this node.
 func (n *Node) Dump(source []byte, level int) {
 	gma.DumpHelper(n, source, level, map[string]string{
-		"pos":  `"` + string(n.pos.Value(source)) + `"`,
+		"pos":  `"` + string(n.tex(source)) + `"`,
 		"mode": n.mode.String(),
 	}, nil)
 }
 
-type parser struct{}
+// sourceOffset returns the offset in the source of the byte at offset in the
+// TeX, or the start of the TeX if offset is negative.
+func (n *Node) sourceOffset(offset int) int {
+	if offset < 0 {
+		return n.pos.Start
+	}
+	if n.lines == nil {
+		return n.pos.Start + offset
+	}
+	for _, line := range n.lines {
+		if offset < line.Padding {
+			return line.Start
+		}
+		offset -= line.Padding
+		if offset < line.Stop-line.Start {
+			return line.Start + offset
+		}
+		offset -= line.Stop - line.Start
+	}
+	return n.pos.Stop
+}
+
+func (n *Node) tex(source []byte) []byte {
+	if n.lines == nil {
+		return source[n.pos.Start:n.pos.Stop]
+	}
+	var result []byte
+	for _, line := range n.lines {
+		result = line.ConcatPadding(result)
+		result = append(result, line.Value(source)...)
+	}
+	return result
+}
+
+// Block represents a block of display TeX in the Goldmark AST tree. Its only
+// child, if any, is the Node holding the TeX.
+type Block struct {
+	gma.BaseBlock
+
+	// open and close are the lines that open and close the block, see
+	// Node.Delimiters.
+	open, close []byte
+}
+
+// KindTexBlock indicates that a node is of kind qjskatex.Block.
+var KindTexBlock = gma.NewNodeKind("TeXBlock")
+
+// Kind returns the kind of this node.
+func (n *Block) Kind() gma.NodeKind {
+	return KindTexBlock
+}
+
+// IsRaw returns true, as the contents of the block are not markdown.
+func (n *Block) IsRaw() bool {
+	return true
+}
+
+// Dump dumps a textual representation this node.
+func (n *Block) Dump(source []byte, level int) {
+	gma.DumpHelper(n, source, level, nil, nil)
+}
+
+// Delimiters is a set of flags enabling TeX delimiters in addition to $ and $$,
+// which are always enabled.
+type Delimiters int
+
+// Possible values of Delimiters:
+const (
+	// SingleBackslash enables \(...\) for inline TeX and \[...\] for display
+	// TeX, like pandoc's tex_math_single_backslash extension.
+	SingleBackslash Delimiters = 0b01
+	// DoubleBackslash enables \\(...\\) for inline TeX and \\[...\\] for
+	// display TeX, like pandoc's tex_math_double_backslash extension.
+	DoubleBackslash Delimiters = 0b10
+	// DollarBacktick enables $`...`$ for inline TeX, like GitLab. As with code
+	// spans, backslashes do not escape the closing delimiter.
+	DollarBacktick Delimiters = 0b100
+)
+
+type parser struct {
+	delims Delimiters
+}
 
 type context struct {
 	// buf is a single buffer to render TeX into for the entire run. Goldmark only
@@ -59,16 +234,100 @@ type context struct {
 	// Before (initialBufSize=4096): BenchmarkSequencesAndSeries-4          20         436353295 ns/op         6995413 B/op       2169 allocs/op
 	// Before (initialBufSize=8192): BenchmarkSequencesAndSeries-4          20         356185385 ns/op         8565546 B/op       2083 allocs/op
 	// After: 						 BenchmarkSequencesAndSeries-4          20         278764605 ns/op         3978978 B/op       1532 allocs/op
-	buf   []byte
-	count int
+	buf     []byte
+	count   int
+	display int
+
+	// macros is the document's macro table, when definitions persist across TeX.
+	macros *macroTable
+
+	diagnostics []Diagnostic
+
+	// formulas holds the TeX rendered, in order, mostly as slices of the
+	// source. It is only copied out and deduplicated if ReportKatex is called.
+	formulas [][]byte
+
+	// mu guards the statistics recorded as TeX is rendered, which happens
+	// concurrently with Extension.RenderWorkers.
+	mu         sync.Mutex
+	hits       int
+	renderTime time.Duration
+}
+
+// macroTable is a table of macros, along with a string identifying its
+// contents for use in cache keys.
+type macroTable struct {
+	defs map[string]string
+	key  string
+}
+
+func newMacroTable(defs map[string]string) *macroTable {
+	result := &macroTable{defs: make(map[string]string, len(defs))}
+	for name, macro := range defs {
+		result.defs[name] = macro
+	}
+	result.key = encodeMacros(result.defs)
+	return result
+}
+
+// encodeMacros encodes macros canonically, as map keys are sorted.
+func encodeMacros(macros map[string]string) string {
+	result, _ := json.Marshal(macros)
+	return string(result)
 }
 
 var ctxKey = gmp.NewContextKey()
 
+func getContext(pc gmp.Context) *context {
+	if v := pc.Get(ctxKey); v != nil {
+		return (v).(*context)
+	}
+	ctx := new(context)
+	ctx.buf = make([]byte, 4096)
+	pc.Set(ctxKey, ctx)
+	return ctx
+}
+
 func (p *parser) Trigger() []byte {
+	if p.delims&(SingleBackslash|DoubleBackslash) != 0 {
+		return []byte{'$', '\\'}
+	}
 	return []byte{'$'}
 }
 
+var (
+	singleInlineClose  = []byte(`\)`)
+	singleDisplayClose = []byte(`\]`)
+	doubleInlineClose  = []byte(`\\)`)
+	doubleDisplayClose = []byte(`\\]`)
+
+	dollarBacktickClose = []byte("`$")
+)
+
+// backslashClose returns the closing delimiter for the backslash delimiter at
+// the start of line, or nil if line does not start with an enabled one.
+func (p *parser) backslashClose(line []byte) (close []byte, mode katex.Mode) {
+	if line[1] == '\\' {
+		if p.delims&DoubleBackslash == 0 || len(line) < 3 {
+			return
+		}
+		switch line[2] {
+		case '(':
+			return doubleInlineClose, katex.Inline
+		case '[':
+			return doubleDisplayClose, katex.Display
+		}
+	} else if p.delims&SingleBackslash != 0 {
+		switch line[1] {
+		case '(':
+			return singleInlineClose, katex.Inline
+		case '[':
+			return singleDisplayClose, katex.Display
+		}
+	}
+	return
+}
+
 func blank(s []byte) bool {
 	result := true
 	for c := 0; c < len(s); c++ {
@@ -80,6 +339,43 @@ func blank(s []byte) bool {
 	return result
 }
 
+// scanClose finds close in the paragraph, starting from offset in the line
+// [lStart, lEnd) and continuing over following lines. If escapes is set, close
+// is not matched after a backslash. It returns the position of close, or 0 if it
+// was not found, and the line number and end of the line it was found on.
+func scanClose(buf []byte, ln, lStart, lEnd, offset int, close []byte, escapes bool) (end, endLn, endLEnd int) {
+	line := buf[lStart:lEnd]
+	for {
+		for c := offset; c < len(line); c++ {
+			if bytes.HasPrefix(line[c:], close) {
+				return lStart + c, ln, lEnd
+			}
+			if escapes && line[c] == '\\' {
+				c++
+			}
+		}
+		if lEnd == len(buf) {
+			// End of buffer, no closing delimiter
+			return 0, ln, lEnd
+		}
+		rest := buf[lEnd:]
+		// Consume at most one \n
+		c := 1
+		for c < len(rest) && rest[c] != '\n' {
+			c++
+		}
+		if blank(rest[:c]) {
+			// End of paragraph, no closing delimiter
+			return 0, ln, lEnd
+		}
+		lStart = lEnd
+		lEnd = lStart + c
+		line = buf[lStart:lEnd]
+		ln++
+		offset = 0
+	}
+}
+
 func (p *parser) Parse(parent gma.Node, block gmt.Reader, pc gmp.Context) gma.Node {
 	// Pandoc only parses TeX as inline; follow their example. Both $ and $$ always
 	// behave like `, and never like ```.  We give TeX the same parsing rules as `,
@@ -101,7 +397,24 @@ func (p *parser) Parse(parent gma.Node, block gmt.Reader, pc gmp.Context) gma.No
 	advance := 0
 	var mode katex.Mode
 
-	if line[1] == '$' {
+	if line[0] == '\\' {
+		// \( \[ \\( \\[
+		var close []byte
+		close, mode = p.backslashClose(line)
+		if close == nil {
+			return nil
+		}
+		// Opening and closing delimiters have the same length.
+		start = lStart + len(close)
+		end, ln, lEnd = scanClose(buf, ln, lStart, lEnd, len(close), close, true)
+		advance = len(close)
+	} else if line[1] == '`' && p.delims&DollarBacktick != 0 {
+		// $`
+		mode = katex.Inline
+		start = lStart + 2
+		end, ln, lEnd = scanClose(buf, ln, lStart, lEnd, 2, dollarBacktickClose, false)
+		advance = 2
+	} else if line[1] == '$' {
 		// $$
 		mode = katex.Display
 		start = lStart + 2
@@ -231,83 +544,555 @@ func (p *parser) Parse(parent gma.Node, block gmt.Reader, pc gmp.Context) gma.No
 		block.Advance(end + advance - pos.Start)
 	}
 
-	var ctx *context
-	if v := pc.Get(ctxKey); v != nil {
-		ctx = (v).(*context)
-	} else {
-		ctx = new(context)
-		ctx.buf = make([]byte, 4096)
-		pc.Set(ctxKey, ctx)
-	}
-
+	ctx := getContext(pc)
 	ctx.count++
+	if mode == katex.Display {
+		ctx.display++
+	}
 
 	return &Node{
 		mode:    mode,
 		pos:     gmt.NewSegment(start, end),
+		open:    buf[pos.Start:start],
+		close:   buf[end : end+advance],
 		context: ctx,
 	}
 }
 
-type renderer struct {
-	warn katex.Mode
+type blockParser struct{}
 
-	noCache bool
-	cache   sync.Map
+func (b *blockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// isDisplayFence reports whether line is $$ on its own.
+func isDisplayFence(line []byte) bool {
+	return bytes.Equal(gmu.TrimRightSpace(gmu.TrimLeftSpace(line)), []byte("$$"))
+}
+
+// closesDisplayBlock reports whether line, a line of a display block after the
+// markers of its containers, is the closing $$. offset is the column line
+// starts at.
+func closesDisplayBlock(line []byte, offset int) bool {
+	w, _ := gmu.IndentWidth(line, offset)
+	return w < 4 && isDisplayFence(line)
+}
+
+// opensCodeFence reports whether line, after the markers of its containers,
+// starts a fenced code block.
+func opensCodeFence(line []byte) bool {
+	w, pos := gmu.IndentWidth(line, 0)
+	line = line[pos:]
+	return w < 4 && (bytes.HasPrefix(line, []byte("```")) || bytes.HasPrefix(line, []byte("~~~")))
+}
+
+// stripContainers removes the markers of the containers of a display block from
+// the start of line, which follows the block's opening line. prefix is the
+// source before the block on its opening line, e.g., "> " in a block quote, or
+// "- " in a list item. It reports false if line does not continue the
+// containers. This follows goldmark's rules closely enough for the containers
+// display blocks are usually in, block quotes and list items.
+func stripContainers(prefix, line []byte) ([]byte, bool) {
+	for {
+		i := bytes.IndexByte(prefix, '>')
+		if i < 0 {
+			break
+		}
+		// Block quotes do not continue lazily, as display blocks are not
+		// paragraphs, so every line needs the marker.
+		c := 0
+		for c < len(line) && c < i+3 && line[c] == ' ' {
+			c++
+		}
+		if c == len(line) || line[c] != '>' {
+			return nil, false
+		}
+		line = line[c+1:]
+		prefix = prefix[i+1:]
+		if len(prefix) > 0 && prefix[0] == ' ' {
+			prefix = prefix[1:]
+			if len(line) > 0 && line[0] == ' ' {
+				line = line[1:]
+			}
+		}
+	}
+	// What is left is the indentation of list items, which blank lines also
+	// continue.
+	if blank(line) {
+		return line, true
+	}
+	if len(line) < len(prefix) || !blank(line[:len(prefix)]) {
+		return nil, false
+	}
+	return line[len(prefix):], true
+}
+
+// hasClosingFence reports whether the display block opened on the line ending
+// at stop in source is closed, following the rule Continue uses, so that an
+// unclosed $$ is left to the inline parser rather than swallowing the rest of
+// the document. A $$ after the start of a fenced code block is taken to be in
+// the code block, and so does not close the display block.
+func hasClosingFence(source []byte, start, stop int) bool {
+	lineStart := bytes.LastIndexByte(source[:start], '\n') + 1
+	prefix := source[lineStart:start]
+	rest := source[stop:]
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+			rest = rest[i+1:]
+		} else {
+			rest = nil
+		}
+		line, ok := stripContainers(prefix, line)
+		if !ok || opensCodeFence(line) {
+			return false
+		}
+		if closesDisplayBlock(line, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *blockParser) Open(parent gma.Node, reader gmt.Reader, pc gmp.Context) (gma.Node, gmp.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !isDisplayFence(line[pos:]) || !hasClosingFence(reader.Source(), segment.Start, segment.Stop) {
+		return nil, gmp.NoChildren
+	}
+	return &Block{open: bytes.TrimSpace(line[pos:])}, gmp.NoChildren
+}
+
+func (b *blockParser) Continue(node gma.Node, reader gmt.Reader, pc gmp.Context) gmp.State {
+	line, segment := reader.PeekLine()
+	if closesDisplayBlock(line, reader.LineOffset()) {
+		newline := 1
+		if line[len(line)-1] != '\n' {
+			newline = 0
+		}
+		reader.Advance(segment.Stop - segment.Start - newline - segment.Padding)
+		node.(*Block).close = bytes.TrimSpace(line)
+		return gmp.Close
+	}
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return gmp.Continue | gmp.NoChildren
+}
+
+func (b *blockParser) Close(node gma.Node, reader gmt.Reader, pc gmp.Context) {
+	block := node.(*Block)
+	appendDisplayTex(block, node.Lines(), block.open, block.close, pc)
+}
+
+// appendDisplayTex appends a Node holding the display TeX in lines, delimited by
+// open and close, to block, unless lines is empty.
+func appendDisplayTex(block gma.Node, lines *gmt.Segments, open, close []byte, pc gmp.Context) {
+	if lines.Len() == 0 {
+		return
+	}
+
+	first := lines.At(0)
+	last := lines.At(lines.Len() - 1)
+	n := &Node{
+		mode:    katex.Display,
+		pos:     gmt.NewSegment(first.Start, last.Stop),
+		open:    open,
+		close:   close,
+		context: getContext(pc),
+	}
+
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		if line.Padding != 0 || (i > 0 && line.Start != lines.At(i-1).Stop) {
+			n.lines = lines.Sliced(0, lines.Len())
+			break
+		}
+	}
+
+	n.context.count++
+	n.context.display++
+	block.AppendChild(block, n)
+}
+
+func (b *blockParser) CanInterruptParagraph() bool {
+	return false
+}
+
+func (b *blockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type fenceTransformer struct {
+	languages [][]byte
+}
+
+func (t *fenceTransformer) isTex(language []byte) bool {
+	for _, l := range t.languages {
+		if bytes.Equal(language, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Transform replaces fenced code blocks in TeX languages with display blocks.
+func (t *fenceTransformer) Transform(doc *gma.Document, reader gmt.Reader, pc gmp.Context) {
+	source := reader.Source()
+	var fences []*gma.FencedCodeBlock
+	gma.Walk(doc, func(n gma.Node, entering bool) (gma.WalkStatus, error) {
+		if entering {
+			if fence, ok := n.(*gma.FencedCodeBlock); ok {
+				if t.isTex(fence.Language(source)) {
+					fences = append(fences, fence)
+				}
+				return gma.WalkSkipChildren, nil
+			}
+		}
+		return gma.WalkContinue, nil
+	})
+	for _, fence := range fences {
+		block := &Block{}
+		open, close := fenceDelimiters(source, fence)
+		appendDisplayTex(block, fence.Lines(), open, close, pc)
+		parent := fence.Parent()
+		parent.ReplaceChild(parent, fence, block)
+	}
+}
+
+// fenceDelimiters returns the opening and closing lines of fence, a fenced code
+// block in a TeX language, without the markers of its containers. close is nil
+// if fence is closed by the end of its container or the document.
+func fenceDelimiters(source []byte, fence *gma.FencedCodeBlock) (open, close []byte) {
+	// Fences in TeX languages always have info.
+	info := fence.Info.Segment
+	line := source[bytes.LastIndexByte(source[:info.Start], '\n')+1 : info.Stop]
+	open = bytes.TrimSpace(line[bytes.IndexAny(line, "`~"):])
+
+	lines := fence.Lines()
+	if lines.Len() == 0 {
+		return open, nil
+	}
+	rest := source[lines.At(lines.Len()-1).Stop:]
+	if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	i := bytes.IndexByte(rest, open[0])
+	if i < 0 || len(bytes.Trim(rest[:i], "> \t")) != 0 {
+		return open, nil
+	}
+	close = bytes.TrimSpace(rest[i:])
+	if len(close) < 3 || len(bytes.Trim(close, string(open[:1]))) != 0 {
+		return open, nil
+	}
+	return open, close
+}
+
+// renderTransformer renders the TeX of a document after it is parsed.
+type renderTransformer struct {
+	r       *renderer
+	workers int
+}
+
+// Transform renders the TeX of doc, storing the results on its nodes, unless doc
+// is being parsed by Prerender, which renders the TeX itself.
+func (t *renderTransformer) Transform(doc *gma.Document, reader gmt.Reader, pc gmp.Context) {
+	if pc.Get(prerenderKey) != nil {
+		return
+	}
+	t.r.renderDocument(reader.Source(), doc, t.workers)
 }
 
-type cacheKey struct {
-	str string
-	m   katex.Mode
+type renderer struct {
+	// options are the options TeX is rendered with, other than the mode.
+	options        katex.Options
+	documentMacros bool
+	errorRenderer  ErrorRenderer
+	timeout        time.Duration
+	pool           *katex.Pool
+	observer       Observer
+
+	// opts identifies the options, other than the mode, that TeX is rendered
+	// with, so that results rendered with different options are never confused.
+	opts string
+
+	noCache bool
+	cache   Cache
+	counts  *cacheCounts
 }
 
-type cacheValue struct {
-	str string
-	err error
+// cacheCounts counts cache lookups. It is allocated separately from the
+// renderer so that its fields are 64-bit aligned for atomic access.
+type cacheCounts struct {
+	hits   uint64
+	misses uint64
 }
 
 func asString(buf []byte) string {
 	return *(*string)(unsafe.Pointer(&buf))
 }
 
-func (r *renderer) load(key []byte, m katex.Mode) (cv cacheValue, ok bool) {
+func (r *renderer) load(key []byte, m katex.Mode, opts string) (ce CacheEntry, ok bool) {
 	if r.noCache == false {
-		ck := cacheKey{str: asString(key), m: m}
-		result, _ := r.cache.Load(ck)
-		cv, ok = result.(cacheValue)
+		ce, ok = r.cache.Get(CacheKey{TeX: asString(key), Mode: m, Options: opts})
+		if ok {
+			atomic.AddUint64(&r.counts.hits, 1)
+		} else {
+			atomic.AddUint64(&r.counts.misses, 1)
+		}
 	}
-	return cv, ok
+	return ce, ok
 }
 
-func (r *renderer) store(key []byte, m katex.Mode, value []byte, err error) {
-	if r.noCache == false {
-		r.cache.Store(
-			cacheKey{str: string(key), m: m},
-			cacheValue{str: string(value), err: err},
-		)
+// store caches ce, with the output copied from value. Timeouts are not cached,
+// as they depend on load.
+func (r *renderer) store(key []byte, m katex.Mode, opts string, value []byte, ce CacheEntry) {
+	if r.noCache == false && ce.Err != katex.ErrTimeout {
+		ce.HTML = string(value)
+		r.cache.Put(CacheKey{TeX: string(key), Mode: m, Options: opts}, ce)
 	}
 }
 
+// renderReporting renders TeX with opts, reporting parse errors even when they
+// are rendered rather than returned. Rendering stops with katex.ErrTimeout once
+// ctx is done or, if r.timeout is positive, after that long.
+func (r *renderer) renderReporting(ctx stdcontext.Context, dest *[]byte, tex []byte, opts *katex.Options) (*katex.ParseError, error) {
+	if r.timeout > 0 {
+		var cancel stdcontext.CancelFunc
+		ctx, cancel = stdcontext.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	render := katex.RenderReporting
+	if r.pool != nil {
+		render = r.pool.RenderReporting
+	}
+	return render(ctx, dest, tex, opts)
+}
+
 func (r *renderer) render(w gmu.BufWriter, source []byte, gmnode gma.Node, entering bool) (gma.WalkStatus, error) {
 	if entering {
 		return gma.WalkContinue, nil
 	}
 	n := gmnode.(*Node)
-	tex := source[n.pos.Start:n.pos.Stop]
-	val, ok := r.load(tex, n.mode)
+	tex := n.tex(source)
+	var out []byte
+	var pe *katex.ParseError
+	var err error
+	switch {
+	case r.documentMacros:
+		out, pe, err = r.renderGlobal(stdcontext.Background(), tex, n)
+	case n.rendered:
+		out, pe, err = gmu.StringToReadOnlyBytes(n.result.HTML), n.result.ParseError, n.result.Err
+	case n.batched:
+		// renderDocument already looked it up.
+		out, pe, err = r.renderMiss(stdcontext.Background(), tex, n, time.Now())
+	default:
+		out, pe, err = r.renderTex(stdcontext.Background(), tex, n)
+	}
+	n.context.formulas = append(n.context.formulas, tex)
+	if pe != nil && err == nil && r.errorRenderer != nil {
+		r.errorRenderer(w, n, tex, pe)
+	} else {
+		w.Write(out)
+	}
+	if pe != nil {
+		offset := n.sourceOffset(pe.Position)
+		n.context.diagnostics = append(n.context.diagnostics, newDiagnostic(source, n, offset, pe))
+		if err == error(pe) {
+			err = &ParseError{Offset: offset, Err: pe}
+		}
+	}
+	return gma.WalkContinue, err
+}
+
+func (r *renderer) renderTex(ctx stdcontext.Context, tex []byte, n *Node) ([]byte, *katex.ParseError, error) {
+	start := time.Now()
+	val, ok := r.load(tex, n.mode, r.opts)
 	if ok {
-		w.WriteString(val.str)
-		return gma.WalkContinue, val.err
+		r.observeHit(n, tex, time.Since(start), &val)
+		return gmu.StringToReadOnlyBytes(val.HTML), val.ParseError, val.Err
 	}
+	return r.renderMiss(ctx, tex, n, start)
+}
 
-	err := katex.Render(&n.context.buf, tex, n.mode|r.warn)
-	w.Write(n.context.buf)
-	r.store(tex, n.mode, n.context.buf, err)
-	return gma.WalkContinue, err
+// renderMiss renders TeX that was not found in the cache, and caches it. start
+// is when the lookup started.
+func (r *renderer) renderMiss(ctx stdcontext.Context, tex []byte, n *Node, start time.Time) ([]byte, *katex.ParseError, error) {
+	opts := r.options
+	opts.DisplayMode = n.mode&katex.Display != 0
+	pe, err := r.renderReporting(ctx, &n.context.buf, tex, &opts)
+	r.store(tex, n.mode, r.opts, n.context.buf, CacheEntry{Err: err, ParseError: pe})
+	r.observe(n, RenderEvent{TeX: tex, Mode: n.mode, Duration: time.Since(start), OutputSize: len(n.context.buf), Err: err, ParseError: pe})
+	return n.context.buf, pe, err
+}
+
+// batchTeX is TeX in a document that is rendered by renderDocument, and the
+// nodes that hold it.
+type batchTeX struct {
+	tex   []byte
+	nodes []*Node
+}
+
+// renderDocument looks up the TeX of every node in doc, and renders the TeX that
+// is not cached with up to workers goroutines, each rendering a share of it with
+// renderBatch.
+func (r *renderer) renderDocument(source []byte, doc gma.Node, workers int) {
+	var batch []*batchTeX
+	pending := make(map[CacheKey]*batchTeX)
+	gma.Walk(doc, func(node gma.Node, entering bool) (gma.WalkStatus, error) {
+		n, ok := node.(*Node)
+		if !ok || !entering {
+			return gma.WalkContinue, nil
+		}
+		n.batched = true
+		tex := n.tex(source)
+		key := CacheKey{TeX: asString(tex), Mode: n.mode}
+		if b, ok := pending[key]; ok {
+			b.nodes = append(b.nodes, n)
+			return gma.WalkContinue, nil
+		}
+		start := time.Now()
+		if val, ok := r.load(tex, n.mode, r.opts); ok {
+			r.observeHit(n, tex, time.Since(start), &val)
+			n.result, n.rendered = val, true
+			return gma.WalkContinue, nil
+		}
+		b := &batchTeX{tex: tex, nodes: []*Node{n}}
+		pending[key] = b
+		batch = append(batch, b)
+		return gma.WalkContinue, nil
+	})
+	if workers > len(batch) {
+		workers = len(batch)
+	}
+	if workers <= 1 {
+		r.renderBatch(batch)
+		return
+	}
+	var wg sync.WaitGroup
+	size := (len(batch) + workers - 1) / workers
+	for start := 0; start < len(batch); start += size {
+		end := start + size
+		if end > len(batch) {
+			end = len(batch)
+		}
+		wg.Add(1)
+		go func(batch []*batchTeX) {
+			defer wg.Done()
+			r.renderBatch(batch)
+		}(batch[start:end])
+	}
+	wg.Wait()
+}
+
+// renderBatch renders batch, caching the results and setting the results of its
+// nodes. If r.timeout is positive, each TeX is rendered alone, so that it has a
+// deadline of its own. Otherwise, batch is rendered with a single call to
+// katex.RenderBatch, and TeX that fails to render other than to parse is left to
+// be rendered alone, so that the error, e.g., katex.ErrOutOfMemory, is its own
+// rather than the batch's.
+func (r *renderer) renderBatch(batch []*batchTeX) {
+	if len(batch) == 0 {
+		return
+	}
+	if r.timeout > 0 {
+		for _, b := range batch {
+			// Not reused, as the nodes keep the output.
+			var buf []byte
+			start := time.Now()
+			opts := r.options
+			opts.DisplayMode = b.nodes[0].mode&katex.Display != 0
+			pe, err := r.renderReporting(stdcontext.Background(), &buf, b.tex, &opts)
+			r.setResult(b, buf, CacheEntry{Err: err, ParseError: pe}, time.Since(start))
+		}
+		return
+	}
+	inline, display := r.options, r.options
+	display.DisplayMode = true
+	items := make([]katex.Item, len(batch))
+	for i, b := range batch {
+		items[i] = katex.Item{TeX: b.tex, Options: &inline}
+		if b.nodes[0].mode&katex.Display != 0 {
+			items[i].Options = &display
+		}
+	}
+	render := katex.RenderBatch
+	if r.pool != nil {
+		render = r.pool.RenderBatch
+	}
+	start := time.Now()
+	results := render(items)
+	share := time.Since(start) / time.Duration(len(items))
+	for i, b := range batch {
+		result := results[i]
+		if _, ok := result.Err.(*katex.ParseError); result.Err != nil && !ok {
+			continue
+		}
+		r.setResult(b, result.HTML, CacheEntry{Err: result.Err, ParseError: result.ParseError}, share)
+	}
+}
+
+// setResult caches the result of b, ce with the output copied from value, and
+// sets it as the result of its nodes, which share value, so it must not be
+// modified afterwards. d is the time taken to render it.
+func (r *renderer) setResult(b *batchTeX, value []byte, ce CacheEntry, d time.Duration) {
+	r.store(b.tex, b.nodes[0].mode, r.opts, value, ce)
+	ce.HTML = gmu.BytesToReadOnlyString(value)
+	for j, n := range b.nodes {
+		n.result, n.rendered = ce, true
+		if j == 0 {
+			r.observe(n, RenderEvent{TeX: b.tex, Mode: n.mode, Duration: d, OutputSize: len(ce.HTML), Err: ce.Err, ParseError: ce.ParseError})
+		} else {
+			r.observeHit(n, b.tex, 0, &ce)
+		}
+	}
+}
+
+// renderGlobal renders TeX using, and adding to, the document's macro table.
+// The output depends on the table as well as the TeX, so the table is part of
+// the cache key, and the table after rendering is cached along with the output.
+func (r *renderer) renderGlobal(ctx stdcontext.Context, tex []byte, n *Node) ([]byte, *katex.ParseError, error) {
+	c := n.context
+	if c.macros == nil {
+		c.macros = newMacroTable(r.options.Macros)
+	}
+
+	start := time.Now()
+	key := r.opts + "global" + c.macros.key
+	val, ok := r.load(tex, n.mode, key)
+	if ok {
+		if val.Macros != nil {
+			c.macros = newMacroTable(val.Macros)
+		}
+		r.observeHit(n, tex, time.Since(start), &val)
+		return gmu.StringToReadOnlyBytes(val.HTML), val.ParseError, val.Err
+	}
+
+	opts := r.options
+	opts.DisplayMode = n.mode&katex.Display != 0
+	opts.Macros = c.macros.defs
+	opts.GlobalGroup = true
+	pe, err := r.renderReporting(ctx, &c.buf, tex, &opts)
+	var changed map[string]string
+	if macrosKey := encodeMacros(c.macros.defs); macrosKey != c.macros.key {
+		c.macros.key = macrosKey
+		changed = newMacroTable(c.macros.defs).defs
+	}
+	r.store(tex, n.mode, key, c.buf, CacheEntry{Err: err, ParseError: pe, Macros: changed})
+	r.observe(n, RenderEvent{TeX: tex, Mode: n.mode, Duration: time.Since(start), OutputSize: len(c.buf), Err: err, ParseError: pe})
+	return c.buf, pe, err
+}
+
+func (r *renderer) renderBlock(w gmu.BufWriter, source []byte, n gma.Node, entering bool) (gma.WalkStatus, error) {
+	if !entering && n.HasChildren() {
+		w.WriteByte('\n')
+	}
+	return gma.WalkContinue, nil
 }
 
 func (r *renderer) RegisterFuncs(reg gmr.NodeRendererFuncRegisterer) {
 	reg.Register(KindTex, r.render)
+	reg.Register(KindTexBlock, r.renderBlock)
 }
 
 // Extension extends Goldmark with KaTeX, implementing goldmark.Extender.
@@ -316,19 +1101,248 @@ type Extension struct {
 	// EnableWarnings allows KaTeX to print warnings to standard out.
 	EnableWarnings bool
 
-	// DisableCache disables the internal cache.
+	// DisableCache disables the cache.
 	DisableCache bool
 
+	// Cache caches rendered TeX. If nil, a MemoryCache limited by
+	// CacheMaxEntries and CacheMaxBytes is used.
+	Cache Cache
+
+	// CacheMaxEntries limits the number of formulas held by the default cache.
+	// Zero is no limit.
+	CacheMaxEntries int
+
+	// CacheMaxBytes approximately limits the bytes of TeX and rendered HTML held
+	// by the default cache. Zero is no limit, unless CacheMaxEntries is also
+	// zero, in which case the limit is DefaultCacheMaxBytes.
+	CacheMaxBytes int
+
+	// ThrowOnError makes TeX that fails to parse stop rendering with a
+	// *ParseError, rather than rendering KaTeX's error message.
+	ThrowOnError bool
+
+	// ErrorRenderer, if set, writes HTML in place of TeX that fails to parse,
+	// rather than KaTeX's error message. It is not used if ThrowOnError is set.
+	ErrorRenderer ErrorRenderer
+
+	// Output selects the markup that KaTeX renders. The default is
+	// katex.HTMLAndMathML.
+	Output katex.Output
+
+	// Timeout, if positive, limits the time spent rendering each piece of TeX.
+	// TeX that takes longer stops rendering with katex.ErrTimeout.
+	Timeout time.Duration
+
+	// Pool, if set, renders TeX with its runtimes, rather than those of the
+	// katex package.
+	Pool *katex.Pool
+
+	// RenderWorkers is the number of goroutines that render the TeX of each
+	// document, so that rendering a single document can use more than one core.
+	// The default is one. Unless DocumentMacros is set, the TeX of each document
+	// that is not cached is rendered after it is parsed, by an AST transformer,
+	// rather than while it is rendered, and the renderer writes the HTML stored on
+	// each Node. Each goroutine renders its share of the TeX with a single call to
+	// katex.RenderBatch, unless Timeout is set, in which case each piece of TeX is
+	// rendered alone. With DocumentMacros, TeX must be rendered in order, so it is
+	// rendered while the document is rendered, and RenderWorkers has no effect.
+	RenderWorkers int
+
+	// Observer, if set, is called for each formula rendered or loaded from the
+	// cache, e.g., to export metrics.
+	Observer Observer
+
+	// Macros defines KaTeX macros for all TeX, e.g., "\\R": "\\mathbb{R}".
+	Macros map[string]string
+
+	// DocumentMacros makes macros defined by TeX, e.g., with \gdef, \def or
+	// \newcommand, apply to all following TeX in the same document. Definitions
+	// with delimited parameters are not supported.
+	DocumentMacros bool
+
+	// Delimiters enables optional TeX delimiters.
+	Delimiters Delimiters
+
+	// DisplayBlocks enables parsing paragraphs that start with $$ on its own line
+	// and end with $$ on its own line as blocks of display TeX, rather than as
+	// inline TeX inside of a paragraph. Display blocks may contain blank lines.
+	DisplayBlocks bool
+
+	// FencedLanguages lists the languages of fenced code blocks that are rendered
+	// as display TeX, like GitHub and GitLab do for the language "math".
+	FencedLanguages []string
+
+	// Stylesheet configures the HTML that includes the KaTeX stylesheet, as
+	// returned by StylesheetHTML, and whether it is written into documents.
+	Stylesheet Stylesheet
+
 	p parser
+	b blockParser
+	t fenceTransformer
 	r renderer
+	w renderTransformer
+	s stylesheetRenderer
+
+	md goldmark.Markdown
 }
 
 // Extend extends m.
 func (e *Extension) Extend(m goldmark.Markdown) {
-	e.r.warn = katex.Warnings(e.EnableWarnings)
+	e.md = m
+	e.r.options = katex.Options{
+		Warnings:     e.EnableWarnings,
+		ThrowOnError: e.ThrowOnError,
+		Output:       e.Output,
+		Macros:       e.Macros,
+	}
 	e.r.noCache = e.DisableCache
+	e.r.cache = e.Cache
+	e.r.counts = &cacheCounts{}
+	if !e.DisableCache && e.Cache == nil {
+		maxBytes := e.CacheMaxBytes
+		if maxBytes == 0 && e.CacheMaxEntries == 0 {
+			maxBytes = DefaultCacheMaxBytes
+		}
+		e.r.cache = NewMemoryCache(e.CacheMaxEntries, maxBytes)
+	}
+	e.r.documentMacros = e.DocumentMacros
+	e.r.errorRenderer = e.ErrorRenderer
+	e.r.timeout = e.Timeout
+	e.r.pool = e.Pool
+	e.r.observer = e.Observer
+	// Map keys are sorted, so the encoding is canonical.
+	opts, _ := json.Marshal(&e.r.options)
+	e.r.opts = string(opts)
+	e.p.delims = e.Delimiters
 	m.Parser().AddOptions(gmp.WithInlineParsers(gmu.PrioritizedValue{Value: &e.p, Priority: 150}))
+	if e.DisplayBlocks {
+		m.Parser().AddOptions(gmp.WithBlockParsers(gmu.PrioritizedValue{Value: &e.b, Priority: 150}))
+	}
+	if len(e.FencedLanguages) > 0 {
+		for _, l := range e.FencedLanguages {
+			e.t.languages = append(e.t.languages, []byte(l))
+		}
+		m.Parser().AddOptions(gmp.WithASTTransformers(gmu.PrioritizedValue{Value: &e.t, Priority: 150}))
+	}
+	if !e.DocumentMacros {
+		workers := e.RenderWorkers
+		if workers < 1 {
+			workers = 1
+		}
+		e.w = renderTransformer{r: &e.r, workers: workers}
+		// After the fence transformer, so that its nodes are rendered.
+		m.Parser().AddOptions(gmp.WithASTTransformers(gmu.PrioritizedValue{Value: &e.w, Priority: 200}))
+	}
 	m.Renderer().AddOptions(gmr.WithNodeRenderers(gmu.PrioritizedValue{Value: &e.r, Priority: 150}))
+	if e.Stylesheet.Inject {
+		e.s.html = e.Stylesheet.html()
+		m.Renderer().AddOptions(gmr.WithNodeRenderers(gmu.PrioritizedValue{Value: &e.s, Priority: 150}))
+	}
+}
+
+// CacheStats reports the effectiveness and size of the cache. Entries, Bytes and
+// Evictions are only reported if the cache is a CacheInspector.
+func (e *Extension) CacheStats() CacheStats {
+	var stats CacheStats
+	if e.r.counts == nil {
+		return stats
+	}
+	if c, ok := e.r.cache.(CacheInspector); ok {
+		stats = c.Stats()
+	}
+	stats.Hits = atomic.LoadUint64(&e.r.counts.hits)
+	stats.Misses = atomic.LoadUint64(&e.r.counts.misses)
+	return stats
+}
+
+// ResetCache resets the hits and misses reported by CacheStats, and removes all
+// entries from the cache if it is a CacheInspector.
+func (e *Extension) ResetCache() {
+	if e.r.counts == nil {
+		return
+	}
+	if c, ok := e.r.cache.(CacheInspector); ok {
+		c.Reset()
+	}
+	atomic.StoreUint64(&e.r.counts.hits, 0)
+	atomic.StoreUint64(&e.r.counts.misses, 0)
+}
+
+// RangeCache calls f for each entry in the cache until f returns false, e.g., to
+// export the cache. It does nothing unless the cache is a CacheInspector.
+func (e *Extension) RangeCache(f func(key CacheKey, entry CacheEntry) bool) {
+	if c, ok := e.r.cache.(CacheInspector); ok {
+		c.Range(f)
+	}
+}
+
+// ReportKatexErrors reports the TeX that KaTeX failed to parse while rendering
+// documents parsed using the Goldmark parser Context pc, in the order it was
+// rendered. These are reported whether or not Extension.ThrowOnError is set.
+func ReportKatexErrors(pc gmp.Context) []Diagnostic {
+	var result []Diagnostic
+	if v := pc.Get(ctxKey); v != nil {
+		result = (v).(*context).diagnostics
+	}
+	return result
+}
+
+// Report summarizes the TeX in documents parsed and rendered using a Goldmark
+// parser Context.
+type Report struct {
+	// Inline and Display count the TeX nodes seen by the parser in each mode.
+	Inline, Display int
+	// Errors counts the TeX that KaTeX failed to parse, as reported by
+	// ReportKatexErrors.
+	Errors int
+	// CacheHits counts the TeX that was loaded from the cache rather than
+	// rendered.
+	CacheHits int
+	// RenderTime is the total time spent looking up and rendering TeX, as
+	// reported to Extension.Observer. With Extension.RenderWorkers, it may
+	// exceed the time taken to render the documents.
+	RenderTime time.Duration
+	// Formulas lists the unique TeX rendered, in the order it was first
+	// rendered.
+	Formulas []string
+}
+
+// ReportKatex summarizes the TeX in documents parsed and rendered using the
+// Goldmark parser Context pc, e.g., to decide whether a page needs the KaTeX
+// stylesheet. The TeX is read from the sources of the documents when it is
+// reported, so they must not have been modified since they were rendered.
+func ReportKatex(pc gmp.Context) Report {
+	var result Report
+	if v := pc.Get(ctxKey); v != nil {
+		ctx := (v).(*context)
+		ctx.mu.Lock()
+		defer ctx.mu.Unlock()
+		result = Report{
+			Inline:     ctx.count - ctx.display,
+			Display:    ctx.display,
+			Errors:     len(ctx.diagnostics),
+			CacheHits:  ctx.hits,
+			RenderTime: ctx.renderTime,
+			Formulas:   uniqueFormulas(ctx.formulas),
+		}
+	}
+	return result
+}
+
+// uniqueFormulas returns the unique TeX in formulas, in the order it first
+// appears.
+func uniqueFormulas(formulas [][]byte) []string {
+	var result []string
+	seen := make(map[string]struct{}, len(formulas))
+	for _, tex := range formulas {
+		if _, ok := seen[asString(tex)]; ok {
+			continue
+		}
+		formula := string(tex)
+		seen[formula] = struct{}{}
+		result = append(result, formula)
+	}
+	return result
 }
 
 // ReportKatexNodes reports the number of KaTeX nodes seen by parsers using the Goldmark parser Context pc.
@@ -2,13 +2,27 @@ package qjskatex
 
 import (
 	"bytes"
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/graemephi/goldmark-qjs-katex/katex"
 
 	gm "github.com/yuin/goldmark"
+	gma "github.com/yuin/goldmark/ast"
 	gmp "github.com/yuin/goldmark/parser"
 	gmhtml "github.com/yuin/goldmark/renderer/html"
+	gmt "github.com/yuin/goldmark/text"
+	gmu "github.com/yuin/goldmark/util"
 )
 
 //go:generate go run gen.go $GOOS
@@ -97,6 +111,862 @@ func TestUnicode(t *testing.T) {
 	}
 }
 
+func TestDelimiters(t *testing.T) {
+	dollars := gm.New(gm.WithExtensions(&Extension{}))
+	single := gm.New(gm.WithExtensions(&Extension{Delimiters: SingleBackslash}))
+	double := gm.New(gm.WithExtensions(&Extension{Delimiters: DoubleBackslash}))
+	both := gm.New(gm.WithExtensions(&Extension{Delimiters: SingleBackslash | DoubleBackslash}))
+	gitlab := gm.New(gm.WithExtensions(&Extension{Delimiters: DollarBacktick}))
+
+	tests := []struct {
+		md       gm.Markdown
+		in, same string
+	}{
+		{single, `\(x\)`, `$x$`},
+		{single, `\[x\]`, `$$x$$`},
+		{single, `a \(x\) b \[y\] c`, `a $x$ b $$y$$ c`},
+		{single, "\\(x\ny\\)", "$x\ny$"},
+		{single, `\(\\)\)`, `$\\)$`},
+		{single, `\(x`, `\(x`},
+		{single, `\\(x\\)`, `\\(x\\)`},
+		{single, "\\(x\n\ny\\)", "\\(x\n\ny\\)"},
+		{single, `[\(x\)](y)`, `[$x$](y)`},
+		{single, `[a\(b](c\))`, `[a\(b](c\))`},
+		{double, `\\(x\\)`, `$x$`},
+		{double, `\\[x\\]`, `$$x$$`},
+		{double, `\(x\)`, `\(x\)`},
+		{both, `\(x\) \\(y\\)`, `$x$ $y$`},
+		{gitlab, "$`a^2`$", "$a^2$"},
+		{gitlab, "$`a\nb`$", "$a\nb$"},
+		{gitlab, "$`\\$`$", `$\$$`},
+		{gitlab, "$`$`", "\\$`$`"},
+		{gitlab, "$`x\n\n`$", "\\$`x\n\n`\\$"},
+		{gitlab, "$x$", "$x$"},
+	}
+
+	for _, test := range tests {
+		var got, want bytes.Buffer
+		if err := test.md.Convert([]byte(test.in), &got); err != nil {
+			t.Errorf("Failed to convert %s: %s", test.in, err)
+			continue
+		}
+		if err := dollars.Convert([]byte(test.same), &want); err != nil {
+			t.Errorf("Failed to convert %s: %s", test.same, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: got, want:\n%s\n-----------------\n%s", test.in, got.String(), want.String())
+		}
+	}
+}
+
+func TestDisplayBlocks(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{DisplayBlocks: true}))
+	inline := gm.New(gm.WithExtensions(&Extension{}))
+
+	display := func(tex string) string {
+		var dest []byte
+		if err := katex.Render(&dest, []byte(tex), katex.Display); err != nil {
+			t.Fatalf("Failed to render %s: %s", tex, err)
+		}
+		return string(dest) + "\n"
+	}
+
+	tests := []struct {
+		in, out string
+	}{
+		{"$$\nx\n$$", display("x\n")},
+		{"$$\nx\n\ny\n$$\n", display("x\n\ny\n")},
+		{"txt\n\n  $$\nx\n  $$\ntxt", "<p>txt</p>\n" + display("x\n") + "<p>txt</p>\n"},
+		{"> $$\n> x\n> y\n> $$", "<blockquote>\n" + display("x\ny\n") + "</blockquote>\n"},
+		{"- $$\n  x\n\n  $$", "<ul>\n<li>\n" + display("x\n\n") + "</li>\n</ul>\n"},
+		{"$$\nx\n  $$\n```\n$$\n```", display("x\n") + "<pre><code>$$\n</code></pre>\n"},
+		{"$$\n$$", ""},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(test.in), &buf); err != nil {
+			t.Errorf("Failed to convert %s: %s", test.in, err)
+			continue
+		}
+		if buf.String() != test.out {
+			t.Errorf("%q: got, want:\n%s\n-----------------\n%s", test.in, buf.String(), test.out)
+		}
+	}
+
+	// Anything that doesn't form a block parses the same as inline TeX.
+	for _, in := range []string{
+		"$$x$$", "$$\nx", "txt\n$$\nx\n$$", "$$ x\n$$",
+		// Closing fences in other containers, or in code, do not close blocks.
+		"$$\nx\n\ntext\n\n> $$\n\nmore",
+		"> $$\n> x\n\n> $$",
+		"- $$\n  x\n\n$$",
+		"$$\nx\n```\n$$\n```",
+	} {
+		var got, want bytes.Buffer
+		if err := md.Convert([]byte(in), &got); err != nil {
+			t.Errorf("Failed to convert %s: %s", in, err)
+			continue
+		}
+		if err := inline.Convert([]byte(in), &want); err != nil {
+			t.Errorf("Failed to convert %s: %s", in, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("%q: got, want:\n%s\n-----------------\n%s", in, got.String(), want.String())
+		}
+	}
+}
+
+func TestFencedLanguages(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{FencedLanguages: []string{"math", "latex"}}))
+
+	display := func(tex string) string {
+		var dest []byte
+		if err := katex.Render(&dest, []byte(tex), katex.Display); err != nil {
+			t.Fatalf("Failed to render %s: %s", tex, err)
+		}
+		return string(dest) + "\n"
+	}
+
+	tests := []struct {
+		in, out string
+	}{
+		{"```math\nx\n```", display("x\n")},
+		{"~~~latex\nx\n\ny\n~~~", display("x\n\ny\n")},
+		{"- ```math\n  x\n  y\n  ```", "<ul>\n<li>\n" + display("x\ny\n") + "</li>\n</ul>\n"},
+		{"```math\n```", ""},
+		{"```tex\nx\n```", "<pre><code class=\"language-tex\">x\n</code></pre>\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(test.in), &buf); err != nil {
+			t.Errorf("Failed to convert %s: %s", test.in, err)
+			continue
+		}
+		if buf.String() != test.out {
+			t.Errorf("%q: got, want:\n%s\n-----------------\n%s", test.in, buf.String(), test.out)
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	macros := map[string]string{"\\R": "\\mathbb{R}"}
+	md := gm.New(gm.WithExtensions(&Extension{Macros: macros}))
+
+	var want []byte
+	if err := katex.RenderWithMacros(&want, []byte("\\R"), katex.Inline, macros); err != nil {
+		t.Fatalf("Failed to render: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := md.Convert([]byte("$\\R$"), &buf); err != nil {
+		t.Fatalf("Failed to convert: %s", err)
+	}
+	if got := buf.String(); got != "<p>"+string(want)+"</p>\n" {
+		t.Errorf("got, want:\n%s\n-----------------\n<p>%s</p>", got, want)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	in := []byte("$x$")
+	for _, output := range []katex.Output{katex.HTMLAndMathML, katex.HTML, katex.MathML} {
+		md := gm.New(gm.WithExtensions(&Extension{Output: output}))
+		var buf bytes.Buffer
+		if err := md.Convert(in, &buf); err != nil {
+			t.Fatalf("Failed to convert %s: %s", in, err)
+		}
+		got := buf.String()
+		html := strings.Contains(got, "katex-html")
+		mathml := strings.Contains(got, "<math")
+		if html != (output != katex.MathML) || mathml != (output != katex.HTML) {
+			t.Errorf("unexpected output for %s: %s", output, got)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{ThrowOnError: true, DisplayBlocks: true}))
+
+	tests := []struct {
+		in     string
+		offset int
+	}{
+		{"$x$", -1},
+		{"a $x^$ b", 4},
+		{"a\n\n$$\\frac{a}$$", 13},
+		{"> $$\n> x\n> }\n> $$", 11},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := md.Convert([]byte(test.in), &buf)
+		if test.offset < 0 {
+			if err != nil {
+				t.Errorf("Failed to convert %q: %s", test.in, err)
+			}
+			continue
+		}
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%q: expected a *ParseError, got %v", test.in, err)
+			continue
+		}
+		if pe.Offset != test.offset {
+			t.Errorf("%q: got offset %d, want %d (%s)", test.in, pe.Offset, test.offset, pe)
+		}
+		var ke *katex.ParseError
+		if !errors.As(err, &ke) {
+			t.Errorf("%q: error does not wrap a *katex.ParseError", test.in)
+		}
+	}
+}
+
+func TestErrorRenderer(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{
+		ErrorRenderer: func(w gmu.BufWriter, n *Node, tex []byte, err *katex.ParseError) {
+			fmt.Fprintf(w, "<code class=\"math-error\" title=\"%s\">%s</code>", n.Mode(), tex)
+		},
+	}))
+	in := []byte("ok $x$ and $x^$\n")
+
+	// Twice, to exercise the cache.
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := md.Convert(in, &buf); err != nil {
+			t.Fatalf("Failed to convert: %s", err)
+		}
+		out := buf.String()
+		if !strings.Contains(out, `<code class="math-error" title="inline">x^</code>`) {
+			t.Errorf("Error renderer not used: %s", out)
+		}
+		if strings.Contains(out, "#cc0000") {
+			t.Errorf("KaTeX error rendered: %s", out)
+		}
+		if !strings.Contains(out, `class="katex"`) {
+			t.Errorf("Valid TeX not rendered: %s", out)
+		}
+	}
+}
+
+func TestNodeDelimiters(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{
+		Delimiters:      SingleBackslash | DoubleBackslash | DollarBacktick,
+		DisplayBlocks:   true,
+		FencedLanguages: []string{"math"},
+	}))
+	tests := []struct {
+		in          string
+		open, close string
+	}{
+		{"a $x$ b", "$", "$"},
+		{"a $$x$$ b", "$$", "$$"},
+		{"a \\(x\\) b", "\\(", "\\)"},
+		{"a \\[x\\] b", "\\[", "\\]"},
+		{"a \\\\(x\\\\) b", "\\\\(", "\\\\)"},
+		{"a $`x`$ b", "$`", "`$"},
+		{"$$\nx\n$$\n", "$$", "$$"},
+		{"> $$\n> x\n> $$\n", "$$", "$$"},
+		{"```math\nx\n```\n", "```math", "```"},
+		{"> ~~~~ math\n> x\n> ~~~~\n", "~~~~ math", "~~~~"},
+		{"> ```math\n> x\n\n`y`\n", "```math", ""},
+	}
+	for _, test := range tests {
+		in := []byte(test.in)
+		doc := md.Parser().Parse(gmt.NewReader(in))
+		found := false
+		gma.Walk(doc, func(n gma.Node, entering bool) (gma.WalkStatus, error) {
+			if n, ok := n.(*Node); ok && entering {
+				found = true
+				open, close := n.Delimiters()
+				if string(open) != test.open || string(close) != test.close || string(n.tex(in)) != "x" && string(n.tex(in)) != "x\n" {
+					t.Errorf("%q: got %q, %q around %q, want %q, %q", test.in, open, close, n.tex(in), test.open, test.close)
+				}
+			}
+			return gma.WalkContinue, nil
+		})
+		if !found {
+			t.Errorf("%q: no TeX", test.in)
+		}
+	}
+
+	// An ErrorRenderer can fall back to the literal text.
+	md = gm.New(gm.WithExtensions(&Extension{
+		ErrorRenderer: func(w gmu.BufWriter, n *Node, tex []byte, err *katex.ParseError) {
+			open, close := n.Delimiters()
+			w.Write(open)
+			w.Write(tex)
+			w.Write(close)
+		},
+	}))
+	var buf bytes.Buffer
+	if err := md.Convert([]byte("a $x^$ b"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<p>a $x^$ b</p>\n" {
+		t.Errorf("Got %q", buf.String())
+	}
+}
+
+func TestReportKatexErrors(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{}))
+	in := []byte("ok $x$\n\nbad $x^$ and $$\\frac{a}$$\n")
+
+	// Twice, to exercise the cache.
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		c := gmp.NewContext()
+		if err := md.Convert(in, &buf, gmp.WithContext(c)); err != nil {
+			t.Fatalf("Failed to convert %s: %s", in, err)
+		}
+		if n := strings.Count(buf.String(), "katex-error"); n != 2 {
+			t.Errorf("expected 2 rendered errors, got %d: %s", n, buf.String())
+		}
+
+		diagnostics := ReportKatexErrors(c)
+		if len(diagnostics) != 2 {
+			t.Fatalf("expected 2 diagnostics, got %v", diagnostics)
+		}
+		want := []struct {
+			tex                  string
+			offset, line, column int
+			mode                 katex.Mode
+		}{
+			{"x^", 14, 3, 7, katex.Inline},
+			{"\\frac{a}", 31, 3, 24, katex.Display},
+		}
+		for j, d := range diagnostics {
+			w := want[j]
+			if string(d.Segment.Value(in)) != w.tex || d.Offset != w.offset || d.Line != w.line || d.Column != w.column || d.Mode != w.mode || d.Err == nil {
+				t.Errorf("got %+v, want %+v", d, w)
+			}
+		}
+	}
+}
+
+func TestDocumentMacros(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{DocumentMacros: true}))
+
+	convert := func(in string) string {
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(in), &buf); err != nil {
+			t.Fatalf("Failed to convert %s: %s", in, err)
+		}
+		return buf.String()
+	}
+
+	// Twice, to exercise the cache.
+	for i := 0; i < 2; i++ {
+		got := convert("$\\gdef\\myvec#1{\\mathbf{#1}}$ $\\myvec{x}$ $\\newcommand\\y{y}$ $\\y$")
+		if strings.Contains(got, "#cc0000") || !strings.Contains(got, "mathbf") {
+			t.Errorf("macros did not persist: %s", got)
+		}
+	}
+
+	got := convert("$\\myvec{x}$")
+	if !strings.Contains(got, "#cc0000") || strings.Contains(got, "mathbf") {
+		t.Errorf("macros persisted between documents: %s", got)
+	}
+	// Definitions made before a parse error are made once.
+	got = convert("$\\gdef\\n{1}$ $\\xdef\\n{\\n+1}}$ $\\n$")
+	if n := strings.Count(got, "<mo>+</mo>"); n != 1 {
+		t.Errorf("expected \\n to be 1+1, got %d +: %s", n, got)
+	}
+	// Macros keep arguments they do not use.
+	for i := 0; i < 2; i++ {
+		got = convert("$\\newcommand\\qq[2]{#2}\\def\\pp#1{x}$ $\\qq ab \\pp cd$")
+		if strings.Contains(got, "#cc0000") || strings.Contains(got, "<mi>a</mi>") || strings.Contains(got, "<mi>c</mi>") ||
+			!strings.Contains(got, "<mi>b</mi><mi>x</mi><mi>d</mi>") {
+			t.Errorf("arguments were lost: %s", got)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	e := &Extension{Timeout: time.Nanosecond}
+	md := gm.New(gm.WithExtensions(e))
+	if err := md.Convert([]byte("$x$"), ioutil.Discard); err != katex.ErrTimeout {
+		t.Errorf("Got %v, want katex.ErrTimeout", err)
+	}
+	if entries := e.CacheStats().Entries; entries != 0 {
+		t.Errorf("Cached %d timeouts", entries)
+	}
+
+	md = gm.New(gm.WithExtensions(&Extension{Timeout: time.Minute}))
+	if err := md.Convert([]byte("$x$"), ioutil.Discard); err != nil {
+		t.Errorf("Failed to convert: %s", err)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := NewMemoryCache(3, 0)
+	key := func(s string) CacheKey { return CacheKey{TeX: s, Mode: katex.Inline} }
+	for _, s := range []string{"a", "b", "c"} {
+		c.Put(key(s), CacheEntry{HTML: s})
+	}
+	if _, ok := c.Get(key("a")); !ok {
+		t.Fatal("Missing a")
+	}
+	c.Put(key("d"), CacheEntry{HTML: "d"})
+	if c.Len() != 3 {
+		t.Errorf("Got %d entries, want 3", c.Len())
+	}
+	if _, ok := c.Get(key("a")); !ok {
+		t.Error("Recently used entry evicted")
+	}
+	if _, ok := c.Get(key("d")); !ok {
+		t.Error("New entry evicted")
+	}
+
+	c = NewMemoryCache(0, 10)
+	for _, s := range []string{"aaaa", "bbbb", "cccc"} {
+		c.Put(key(s), CacheEntry{HTML: s})
+		if c.size > 10 {
+			t.Errorf("Cache holds %d bytes, want at most 10", c.size)
+		}
+	}
+	c.Put(key("a"), CacheEntry{HTML: "too large to cache"})
+	if _, ok := c.Get(key("a")); ok {
+		t.Error("Cached entry larger than the cache")
+	}
+}
+
+type mapCache struct {
+	sync.Mutex
+	entries map[CacheKey]CacheEntry
+}
+
+func (c *mapCache) Get(key CacheKey) (CacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	return e, ok
+}
+
+func (c *mapCache) Put(key CacheKey, entry CacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = entry
+}
+
+func TestCustomCache(t *testing.T) {
+	cache := &mapCache{entries: make(map[CacheKey]CacheEntry)}
+	in := []byte("$x$ and $$y$$")
+	var buf bytes.Buffer
+	if err := gm.New(gm.WithExtensions(&Extension{Cache: cache})).Convert(in, &buf); err != nil {
+		t.Fatalf("Failed to convert: %s", err)
+	}
+	if len(cache.entries) != 2 {
+		t.Fatalf("Got %d cache entries, want 2", len(cache.entries))
+	}
+	for key, entry := range cache.entries {
+		entry.HTML = "<cached " + key.TeX + ">"
+		cache.entries[key] = entry
+	}
+
+	// A second extension shares the cache.
+	buf.Reset()
+	if err := gm.New(gm.WithExtensions(&Extension{Cache: cache})).Convert(in, &buf); err != nil {
+		t.Fatalf("Failed to convert: %s", err)
+	}
+	if want := "<p><cached x> and <cached y></p>\n"; buf.String() != want {
+		t.Errorf("Got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qjskatex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := NewFileCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe := &katex.ParseError{Message: "Expected group", Position: 1, Length: 1}
+	entries := map[CacheKey]CacheEntry{
+		{TeX: "x", Mode: katex.Inline}:                    {HTML: "<x>"},
+		{TeX: "x", Mode: katex.Display}:                   {HTML: "<display x>", Macros: map[string]string{"\\a": "b"}},
+		{TeX: "x^", Mode: katex.Inline}:                   {HTML: "<error>", ParseError: pe},
+		{TeX: "x^", Mode: katex.Inline, Options: "throw"}: {Err: pe, ParseError: pe},
+	}
+	for key, entry := range entries {
+		cache.Put(key, entry)
+	}
+	cache.Put(CacheKey{TeX: "y"}, CacheEntry{Err: katex.ErrBadInput})
+
+	// Entries persist in new caches.
+	cache, err = NewFileCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range entries {
+		got, ok := cache.Get(key)
+		if !ok {
+			t.Errorf("%+v: not cached", key)
+			continue
+		}
+		if got.HTML != want.HTML || fmt.Sprint(got.Macros) != fmt.Sprint(want.Macros) ||
+			(got.ParseError == nil) != (want.ParseError == nil) || (got.Err == nil) != (want.Err == nil) {
+			t.Errorf("%+v: got %+v, want %+v", key, got, want)
+		}
+		if got.Err != nil && got.Err != error(got.ParseError) {
+			t.Errorf("%+v: error is not the parse error", key)
+		}
+	}
+	if _, ok := cache.Get(CacheKey{TeX: "y"}); ok {
+		t.Error("Cached an internal error")
+	}
+
+	md := gm.New(gm.WithExtensions(&Extension{Cache: cache}))
+	in := []byte("$x$ and $$\\frac{1}{2}$$")
+	var first, second bytes.Buffer
+	if err := md.Convert(in, &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.Convert(in, &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("Cached output differs:\n%s\n%s", first.String(), second.String())
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	e := &Extension{CacheMaxEntries: 2}
+	md := gm.New(gm.WithExtensions(e))
+	for _, in := range []string{"$a$ $b$", "$a$ $c$"} {
+		if err := md.Convert([]byte(in), ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := e.CacheStats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Entries != 2 || stats.Evictions != 1 || stats.Bytes <= 0 {
+		t.Errorf("Got %+v", stats)
+	}
+
+	var tex []string
+	e.RangeCache(func(key CacheKey, entry CacheEntry) bool {
+		if !strings.Contains(entry.HTML, "katex") {
+			t.Errorf("%q: got %q", key.TeX, entry.HTML)
+		}
+		tex = append(tex, key.TeX)
+		return true
+	})
+	sort.Strings(tex)
+	if strings.Join(tex, " ") != "a c" {
+		t.Errorf("Got entries %q, want a and c", tex)
+	}
+
+	e.ResetCache()
+	if stats := e.CacheStats(); stats != (CacheStats{}) {
+		t.Errorf("Got %+v after reset", stats)
+	}
+}
+
+func TestPrerender(t *testing.T) {
+	sources := [][]byte{
+		[]byte("$a$ and $b$\n\n$$\\frac{1}{2}$$"),
+		[]byte("$a$ then $x^$"),
+	}
+	for _, e := range []*Extension{{}, {DocumentMacros: true}, {RenderWorkers: 2}} {
+		var events int32
+		e.Observer = ObserverFunc(func(RenderEvent) { atomic.AddInt32(&events, 1) })
+		md := gm.New(gm.WithExtensions(e))
+		done := 0
+		diagnostics, err := e.Prerender(stdcontext.Background(), sources, func(d, total int) {
+			if d != done+1 || total != 5 {
+				t.Errorf("Got progress %d/%d after %d", d, total, done)
+			}
+			done = d
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if observed := atomic.LoadInt32(&events); done != 5 || observed != 5 {
+			t.Errorf("Got %d formulas rendered and %d observed, want 5", done, observed)
+		}
+		if len(diagnostics) != 2 || len(diagnostics[0]) != 0 || len(diagnostics[1]) != 1 || diagnostics[1][0].Offset != 11 {
+			t.Errorf("Got diagnostics %+v", diagnostics)
+		}
+
+		before := e.CacheStats()
+		for _, source := range sources {
+			if err := md.Convert(source, ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stats := e.CacheStats(); stats.Misses != before.Misses {
+			t.Errorf("%+v: converting missed the cache %d times", e, stats.Misses-before.Misses)
+		}
+	}
+
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	cancel()
+	e := &Extension{}
+	gm.New(gm.WithExtensions(e))
+	if _, err := e.Prerender(ctx, sources, nil); err != stdcontext.Canceled {
+		t.Errorf("Got %v, want context.Canceled", err)
+	}
+	if _, err := (&Extension{}).Prerender(stdcontext.Background(), sources, nil); err != ErrNotExtended {
+		t.Errorf("Got %v, want ErrNotExtended", err)
+	}
+
+	// ctx interrupts TeX that is waiting for a runtime, as well as stopping new
+	// TeX from being rendered.
+	p := katex.NewPool(1)
+	defer p.Close()
+	busy, release := stdcontext.WithCancel(stdcontext.Background())
+	defer release()
+	errc := make(chan error)
+	go func() {
+		dest := []byte{}
+		errc <- p.RenderContext(busy, &dest, []byte(`\def\a{\a\a}\a`), &katex.Options{MaxExpand: 1 << 30})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	e = &Extension{Pool: p}
+	gm.New(gm.WithExtensions(e))
+	ctx, cancel = stdcontext.WithTimeout(stdcontext.Background(), 10*time.Millisecond)
+	defer cancel()
+	prerendered := make(chan error)
+	go func() {
+		_, err := e.Prerender(ctx, sources, nil)
+		prerendered <- err
+	}()
+	select {
+	case err := <-prerendered:
+		if err != stdcontext.DeadlineExceeded {
+			t.Errorf("Got %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Prerender waited for the busy runtime")
+		release()
+		<-prerendered
+	}
+	release()
+	<-errc
+	if entries := e.CacheStats().Entries; entries != 0 {
+		t.Errorf("Cached %d interrupted formulas", entries)
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{CacheMaxEntries: 8}))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 32; j++ {
+				var buf bytes.Buffer
+				in := fmt.Sprintf("$x^{%d}$", (i+j)%16)
+				if err := md.Convert([]byte(in), &buf); err != nil {
+					t.Errorf("Failed to convert %q: %s", in, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRenderDocument(t *testing.T) {
+	e := &Extension{
+		DisableCache: true,
+		ErrorRenderer: func(w gmu.BufWriter, n *Node, tex []byte, err *katex.ParseError) {
+			fmt.Fprintf(w, "<code>%s</code>", tex)
+		},
+	}
+	md := gm.New(gm.WithExtensions(e))
+	var expected bytes.Buffer
+	expected.WriteString("<p>")
+	for i, tex := range []string{"a", "b", "a"} {
+		if i > 0 {
+			expected.WriteByte(' ')
+		}
+		if err := katex.RenderTo(&expected, []byte(tex), katex.Inline); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected.WriteString(" <code>x^</code></p>\n")
+
+	// The TeX is rendered in one batch as soon as the document is parsed.
+	in := []byte("$a$ $b$ $a$ $x^$")
+	doc := md.Parser().Parse(gmt.NewReader(in))
+	gma.Walk(doc, func(n gma.Node, entering bool) (gma.WalkStatus, error) {
+		if n, ok := n.(*Node); ok && entering && !n.rendered {
+			t.Errorf("%q was not rendered when parsed", n.tex(in))
+		}
+		return gma.WalkContinue, nil
+	})
+
+	var out bytes.Buffer
+	pc := gmp.NewContext()
+	if err := md.Convert(in, &out, gmp.WithContext(pc)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("Got %q, want %q", out.String(), expected.String())
+	}
+	if d := ReportKatexErrors(pc); len(d) != 1 || d[0].Offset != 14 {
+		t.Errorf("Got diagnostics %+v", d)
+	}
+}
+
+func TestRenderWorkers(t *testing.T) {
+	var in bytes.Buffer
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&in, "$x_{%d}$ $$\\frac{%d}{2}$$\n\n", i, i)
+	}
+	in.WriteString("```math\ny^2\n```\n")
+
+	e := &Extension{RenderWorkers: 4, FencedLanguages: []string{"math"}}
+	md := gm.New(gm.WithExtensions(e))
+	doc := md.Parser().Parse(gmt.NewReader(in.Bytes()))
+	count := 0
+	gma.Walk(doc, func(n gma.Node, entering bool) (gma.WalkStatus, error) {
+		if n, ok := n.(*Node); ok && entering {
+			count++
+			if !n.rendered || !strings.Contains(n.result.HTML, "katex") {
+				t.Errorf("%q was not rendered when parsed", n.tex(in.Bytes()))
+			}
+		}
+		return gma.WalkContinue, nil
+	})
+	if count != 41 {
+		t.Errorf("Got %d nodes, want 41", count)
+	}
+
+	var got, want bytes.Buffer
+	if err := md.Renderer().Render(&got, in.Bytes(), doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gm.New(gm.WithExtensions(&Extension{FencedLanguages: []string{"math"}})).Convert(in.Bytes(), &want); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("Got %q, want %q", got.String(), want.String())
+	}
+
+	// With a Timeout, each TeX is rendered alone, so its error is its own.
+	md = gm.New(gm.WithExtensions(&Extension{RenderWorkers: 4, Timeout: time.Nanosecond}))
+	doc = md.Parser().Parse(gmt.NewReader(in.Bytes()))
+	gma.Walk(doc, func(n gma.Node, entering bool) (gma.WalkStatus, error) {
+		if n, ok := n.(*Node); ok && entering {
+			if !n.rendered || n.result.Err != katex.ErrTimeout {
+				t.Errorf("%q did not time out when parsed", n.tex(in.Bytes()))
+			}
+		}
+		return gma.WalkContinue, nil
+	})
+	if err := md.Renderer().Render(ioutil.Discard, in.Bytes(), doc); err != katex.ErrTimeout {
+		t.Errorf("Got %v, want katex.ErrTimeout", err)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	var mu sync.Mutex
+	var events []RenderEvent
+	e := &Extension{Observer: ObserverFunc(func(e RenderEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		e.TeX = append([]byte(nil), e.TeX...)
+		events = append(events, e)
+	})}
+	md := gm.New(gm.WithExtensions(e))
+	for _, in := range []string{"$a$ $$b$$ $a$ $x^$", "$a$"} {
+		if err := md.Convert([]byte(in), ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []struct {
+		tex  string
+		mode katex.Mode
+		hit  bool
+	}{
+		{"a", katex.Inline, false},
+		{"a", katex.Inline, true},
+		{"b", katex.Display, false},
+		{"x^", katex.Inline, false},
+		{"a", katex.Inline, true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		got := events[i]
+		if string(got.TeX) != w.tex || got.Mode != w.mode || got.CacheHit != w.hit || got.OutputSize == 0 || got.Err != nil {
+			t.Errorf("Event %d: got %+v, want %+v", i, got, w)
+		}
+		if (got.ParseError != nil) != (w.tex == "x^") {
+			t.Errorf("Event %d: got parse error %v", i, got.ParseError)
+		}
+	}
+}
+
+func TestReportKatex(t *testing.T) {
+	md := gm.New(gm.WithExtensions(&Extension{}))
+	in := []byte("$a$ $$b$$ $a$ $x^$")
+	for i, hits := range []int{1, 4} {
+		pc := gmp.NewContext()
+		if err := md.Convert(in, ioutil.Discard, gmp.WithContext(pc)); err != nil {
+			t.Fatal(err)
+		}
+		report := ReportKatex(pc)
+		if report.Inline != 3 || report.Display != 1 || report.Errors != 1 || report.CacheHits != hits || report.RenderTime <= 0 {
+			t.Errorf("Conversion %d: got %+v", i, report)
+		}
+		if strings.Join(report.Formulas, " ") != "a b x^" {
+			t.Errorf("Conversion %d: got formulas %q", i, report.Formulas)
+		}
+	}
+
+	if report := ReportKatex(gmp.NewContext()); report.Inline != 0 || report.Formulas != nil {
+		t.Errorf("Got %+v for an unused context", report)
+	}
+}
+
+func TestStylesheet(t *testing.T) {
+	e := &Extension{}
+	md := gm.New(gm.WithExtensions(e))
+	for _, in := range []string{"no math", "$x$"} {
+		pc := gmp.NewContext()
+		md.Parser().Parse(gmt.NewReader([]byte(in)), gmp.WithContext(pc))
+		got := e.StylesheetHTML(pc)
+		want := ""
+		if in == "$x$" {
+			want = `<link rel="stylesheet" href="` + DefaultStylesheetHref + `" integrity="` + DefaultStylesheetIntegrity + `" crossorigin="anonymous">` + "\n"
+		}
+		if got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+
+	e = &Extension{Stylesheet: Stylesheet{Href: "/static/katex.min.css", Inline: true, Inject: true}}
+	md = gm.New(gm.WithExtensions(e))
+	for _, in := range []string{"no math", "$x$"} {
+		var out bytes.Buffer
+		if err := md.Convert([]byte(in), &out); err != nil {
+			t.Fatal(err)
+		}
+		injected := strings.HasPrefix(out.String(), "<style>")
+		if injected != (in == "$x$") {
+			t.Errorf("%q: got %.100q", in, out.String())
+		}
+		if injected && (!strings.Contains(out.String(), "url(/static/fonts/KaTeX_AMS-Regular.woff2)") || strings.Contains(out.String(), "url(fonts/")) {
+			t.Errorf("%q: fonts not loaded relative to href", in)
+		}
+	}
+
+	e = &Extension{Stylesheet: Stylesheet{Href: "katex.css?a&b"}}
+	if got, want := e.Stylesheet.html(), `<link rel="stylesheet" href="katex.css?a&amp;b">`+"\n"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
 func BenchmarkSequencesAndSeries(b *testing.B) {
 	in := []byte(exchange)
 
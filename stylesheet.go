@@ -0,0 +1,104 @@
+package qjskatex
+
+import (
+	"html"
+	"strings"
+
+	"github.com/graemephi/goldmark-qjs-katex/katex"
+
+	gma "github.com/yuin/goldmark/ast"
+	gmp "github.com/yuin/goldmark/parser"
+	gmr "github.com/yuin/goldmark/renderer"
+	gmu "github.com/yuin/goldmark/util"
+)
+
+// DefaultStylesheetHref is the URL of the stylesheet for the bundled version of
+// KaTeX, as hosted by jsDelivr.
+const DefaultStylesheetHref = "https://cdn.jsdelivr.net/npm/katex@0.16.11/dist/katex.min.css"
+
+// DefaultStylesheetIntegrity is the subresource integrity hash of the
+// stylesheet at DefaultStylesheetHref.
+const DefaultStylesheetIntegrity = "sha384-nB0miv6/jRmo5UMMR1wu3Gz6NLsoTkbqJghGIsx//Rlm+ZU03BU6SQNC66uf4l5+"
+
+// Stylesheet configures the HTML that includes the KaTeX stylesheet in pages
+// with TeX, see Extension.StylesheetHTML.
+type Stylesheet struct {
+	// Href is the URL of katex.min.css. The default is DefaultStylesheetHref.
+	Href string
+	// Integrity is the subresource integrity hash of the stylesheet at Href.
+	// If neither Href nor Integrity are set, it is DefaultStylesheetIntegrity.
+	// Otherwise, if it is empty, the stylesheet is not checked.
+	Integrity string
+	// Inline includes katex.Stylesheet in a <style> element, rather than linking
+	// to Href. Its fonts are still loaded from the fonts directory next to Href.
+	Inline bool
+	// Inject writes the HTML at the start of each document that contains TeX
+	// when it is rendered, for pages without a separate <head>.
+	Inject bool
+}
+
+// html returns the <link> or <style> element for the stylesheet.
+func (s *Stylesheet) html() string {
+	href, integrity := s.Href, s.Integrity
+	if href == "" {
+		href = DefaultStylesheetHref
+		if integrity == "" {
+			integrity = DefaultStylesheetIntegrity
+		}
+	}
+	if s.Inline {
+		fonts := href[:strings.LastIndexByte(href, '/')+1] + "fonts/"
+		return "<style>" + strings.Replace(katex.Stylesheet, "url(fonts/", "url("+fonts, -1) + "</style>\n"
+	}
+	var b strings.Builder
+	b.WriteString(`<link rel="stylesheet" href="`)
+	b.WriteString(html.EscapeString(href))
+	if integrity != "" {
+		b.WriteString(`" integrity="`)
+		b.WriteString(html.EscapeString(integrity))
+		b.WriteString(`" crossorigin="anonymous`)
+	}
+	b.WriteString("\">\n")
+	return b.String()
+}
+
+// StylesheetHTML returns the <link> or <style> element that includes the KaTeX
+// stylesheet, as configured by Extension.Stylesheet, if documents parsed using
+// the Goldmark parser Context pc contained TeX, or the empty string otherwise.
+// It can be used as soon as the documents are parsed, e.g., to write the
+// element into the <head> of the page before rendering the documents.
+func (e *Extension) StylesheetHTML(pc gmp.Context) string {
+	if ReportKatexNodes(pc) == 0 {
+		return ""
+	}
+	return e.Stylesheet.html()
+}
+
+// stylesheetRenderer writes the stylesheet at the start of documents that
+// contain TeX. It replaces goldmark's renderer for documents, which writes
+// nothing.
+type stylesheetRenderer struct {
+	html string
+}
+
+func (r *stylesheetRenderer) RegisterFuncs(reg gmr.NodeRendererFuncRegisterer) {
+	reg.Register(gma.KindDocument, r.renderDocument)
+}
+
+func (r *stylesheetRenderer) renderDocument(w gmu.BufWriter, source []byte, doc gma.Node, entering bool) (gma.WalkStatus, error) {
+	if !entering {
+		return gma.WalkContinue, nil
+	}
+	tex := false
+	gma.Walk(doc, func(n gma.Node, entering bool) (gma.WalkStatus, error) {
+		if _, ok := n.(*Node); ok {
+			tex = true
+			return gma.WalkStop, nil
+		}
+		return gma.WalkContinue, nil
+	})
+	if tex {
+		w.WriteString(r.html)
+	}
+	return gma.WalkContinue, nil
+}